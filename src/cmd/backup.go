@@ -21,11 +21,20 @@
 package cmd
 
 import (
+	"context"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/th3noname/backup-and-sync/src/rclone"
-	"github.com/th3noname/backup-and-sync/src/restic"
+	"github.com/th3noname/backup-and-sync/src/jobs"
+	"github.com/th3noname/backup-and-sync/src/lock"
+)
+
+var (
+	wait        bool
+	waitTimeout time.Duration
+	catchUp     bool
 )
 
 // backupCmd represents the backup command
@@ -36,42 +45,86 @@ var backupCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		initConfig()
 
-		if viper.IsSet("restic") {
-			var resticConf *restic.Config
+		var conf jobs.Config
 
-			err := viper.UnmarshalKey("restic", &resticConf)
-			if err != nil {
-				log.WithError(err).Error("Unmarshal restic configuration failed")
-				return
-			}
+		err := viper.Unmarshal(&conf)
+		if err != nil {
+			log.WithError(err).Error("Unmarshal configuration failed")
+			return
+		}
 
-			r := restic.New(resticConf)
-			err = r.Run()
-			if err != nil {
-				log.WithError(err).Error("restic execution failed")
-				return
-			}
+		runner, err := jobs.New(&conf)
+		if err != nil {
+			log.WithError(err).Error("Loading jobs failed")
+			return
 		}
 
-		if viper.IsSet("rclone") {
-			var rcloneConf *rclone.Config
+		ctx := signalContext()
 
-			err := viper.UnmarshalKey("rclone", &rcloneConf)
-			if err != nil {
-				log.WithError(err).Error("Unmarshal rclone configuration failed")
-				return
-			}
+		var l *lock.Lock
+		if wait {
+			l, err = lock.Wait(ctx, lockFile(), waitTimeout)
+		} else {
+			l, err = lock.Acquire(lockFile())
+		}
 
-			r := rclone.New(rcloneConf)
-			err = r.Run()
-			if err != nil {
-				log.WithError(err).Error("rclone execution failed")
-				return
+		if lock.IsLocked(err) {
+			log.WithError(err).Error("Another backup is running")
+			return
+		} else if err != nil {
+			log.WithError(err).Error("Acquiring lock failed")
+			return
+		}
+
+		defer func() {
+			if err := l.Release(); err != nil {
+				log.WithError(err).Warn("Releasing lock failed")
 			}
+		}()
+
+		if catchUp {
+			err = runDue(ctx, runner)
+		} else {
+			err = runner.Run(ctx)
+		}
+
+		if err != nil {
+			log.WithError(err).Error("backup execution failed")
+			return
 		}
 	},
 }
 
+// runDue runs the jobs whose interval elapsed since their last successful run
+func runDue(ctx context.Context, runner *jobs.Runner) error {
+	ids, err := runner.Due(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		log.Info("No job is due")
+		return nil
+	}
+
+	log.WithField("jobs", ids).Info("Catching up due jobs")
+	return runner.RunJobs(ctx, "catch-up", ids)
+}
+
+// lockFile returns the path of the lock file held during a run
+func lockFile() string {
+	path := viper.GetString("lock-file")
+	if path == "" {
+		return lock.DefaultPath()
+	}
+
+	return path
+}
+
 func init() {
 	rootCmd.AddCommand(backupCmd)
+
+	backupCmd.Flags().BoolVar(&wait, "wait", false, "wait for a running backup to finish instead of exiting")
+	backupCmd.Flags().BoolVar(&catchUp, "catch-up", false, "only run the jobs whose interval (every) elapsed since their last success")
+	backupCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 0, "maximum time to wait for a running backup (default no limit)")
 }
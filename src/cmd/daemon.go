@@ -0,0 +1,77 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/th3noname/backup-and-sync/src/jobs"
+	"github.com/th3noname/backup-and-sync/src/scheduler"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "run the scheduled jobs until stopped",
+	Long: `Runs the jobs with a schedule and the jobs of the schedule groups whenever their cron
+expression activates. A job is never started while it is still running.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+
+		var conf jobs.Config
+
+		err := viper.Unmarshal(&conf)
+		if err != nil {
+			log.WithError(err).Error("Unmarshal configuration failed")
+			return
+		}
+
+		runner, err := jobs.New(&conf)
+		if err != nil {
+			log.WithError(err).Error("Loading jobs failed")
+			return
+		}
+
+		ctx := signalContext()
+
+		if conf.Metrics.Listen != "" {
+			err = runner.ServeMetrics(ctx, conf.Metrics.Listen)
+			if err != nil {
+				log.WithError(err).Error("Starting metrics server failed")
+				return
+			}
+		}
+
+		// metrics are available before the first run
+		runner.ExportMetrics(ctx)
+
+		err = scheduler.New(runner, lockFile()).Run(ctx)
+		if err != nil {
+			log.WithError(err).Error("daemon execution failed")
+			return
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+}
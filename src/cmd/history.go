@@ -0,0 +1,276 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/th3noname/backup-and-sync/src/history"
+	"github.com/th3noname/backup-and-sync/src/jobs"
+)
+
+var (
+	historyJob    string
+	historyStatus string
+	historySince  string
+	historyUntil  string
+	historyLimit  int
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "list the recorded runs",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openHistory()
+		if err != nil {
+			log.WithError(err).Error("Opening history failed")
+			return
+		}
+
+		defer store.Close()
+
+		filter := history.Filter{Job: historyJob, Status: historyStatus, Limit: historyLimit}
+
+		filter.Since, err = parseDate(historySince, false)
+		if err != nil {
+			log.WithError(err).Error("Invalid --since")
+			return
+		}
+
+		filter.Until, err = parseDate(historyUntil, true)
+		if err != nil {
+			log.WithError(err).Error("Invalid --until")
+			return
+		}
+
+		runs, err := store.List(filter)
+		if err != nil {
+			log.WithError(err).Error("Reading history failed")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RUN\tSTART\tDURATION\tTRIGGER\tSTATUS\tJOBS")
+
+		for _, r := range runs {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Start.Local().Format(dateFormat),
+				duration(r.Start, r.End), r.Trigger, r.Status, jobCounts(r.Jobs))
+		}
+
+		w.Flush()
+	},
+}
+
+// historyShowCmd represents the history show command
+var historyShowCmd = &cobra.Command{
+	Use:   "show <runID>",
+	Short: "show the jobs of a recorded run",
+	Long:  ``,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			log.WithField("run", args[0]).Error("Invalid run id")
+			return
+		}
+
+		store, err := openHistory()
+		if err != nil {
+			log.WithError(err).Error("Opening history failed")
+			return
+		}
+
+		defer store.Close()
+
+		r, err := store.Get(id)
+		if err != nil {
+			log.WithError(err).Error("Reading history failed")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Run:\t%d\n", r.ID)
+		fmt.Fprintf(w, "Trigger:\t%s\n", r.Trigger)
+		fmt.Fprintf(w, "Start:\t%s\n", r.Start.Local().Format(dateFormat))
+		fmt.Fprintf(w, "End:\t%s\n", r.End.Local().Format(dateFormat))
+		fmt.Fprintf(w, "Duration:\t%s\n", duration(r.Start, r.End))
+		fmt.Fprintf(w, "Status:\t%s\n", r.Status)
+
+		if r.Error != "" {
+			fmt.Fprintf(w, "Error:\t%s\n", r.Error)
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "JOB\tTYPE\tSTATUS\tSTART\tDURATION\tATTEMPTS\tSTATISTICS")
+
+		for _, j := range r.Jobs {
+			start := "-"
+			if !j.Start.IsZero() {
+				start = j.Start.Local().Format(dateFormat)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", j.ID, j.Type, j.Status, start,
+				duration(j.Start, j.End), j.Attempts, statistics(j))
+		}
+
+		w.Flush()
+
+		for _, j := range r.Jobs {
+			if j.Error != "" {
+				fmt.Printf("\n%s: %s\n", j.ID, j.Error)
+			}
+		}
+	},
+}
+
+const dateFormat = "2006-01-02 15:04:05"
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyShowCmd)
+
+	historyCmd.Flags().StringVar(&historyJob, "job", "", "only show runs that executed the job with this id")
+	historyCmd.Flags().StringVar(&historyStatus, "status", "", "only show runs with this status (status of --job if set)")
+	historyCmd.Flags().StringVar(&historySince, "since", "", "only show runs started at or after this date (YYYY-MM-DD[ HH:MM])")
+	historyCmd.Flags().StringVar(&historyUntil, "until", "", "only show runs started at or before this date (YYYY-MM-DD[ HH:MM])")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "maximum number of runs, 0 shows all runs")
+}
+
+// openHistory opens the history database of the configuration read-only
+func openHistory() (*history.Store, error) {
+	initConfig()
+
+	var conf jobs.Config
+
+	err := viper.Unmarshal(&conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal configuration failed")
+	}
+
+	return history.OpenReadOnly(conf.HistoryPath())
+}
+
+// parseDate parses a date with an optional time in local time. A date without time is the start
+// of the day or the end of the day if endOfDay is set. An empty value results in the zero time.
+func parseDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid date \"%s\"", value)
+	}
+
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	return t, nil
+}
+
+func duration(start, end time.Time) string {
+	if start.IsZero() || end.IsZero() {
+		return "-"
+	}
+
+	return end.Sub(start).Round(time.Second).String()
+}
+
+// jobCounts summarises the status of the jobs, e.g. "2 success, 1 failed"
+func jobCounts(jobs []history.Job) string {
+	var order []string
+
+	counts := make(map[string]int)
+	for _, j := range jobs {
+		if counts[j.Status] == 0 {
+			order = append(order, j.Status)
+		}
+
+		counts[j.Status]++
+	}
+
+	parts := make([]string, len(order))
+	for i, status := range order {
+		parts[i] = fmt.Sprintf("%d %s", counts[status], status)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// statistics formats the restic or rclone statistics or the differences found by a check
+func statistics(j history.Job) string {
+	switch {
+	case j.Differences != nil:
+		d := j.Differences
+		return fmt.Sprintf("%d differences (missing on destination: %d, missing on source: %d, differ: %d, errors: %d)",
+			d.Total(), d.MissingOnDst, d.MissingOnSrc, d.Differ, d.Errors)
+	case j.Restic != nil:
+		return fmt.Sprintf("snapshot %s, %d new, %d changed files, %s added",
+			shortID(j.Restic.SnapshotID), j.Restic.FilesNew, j.Restic.FilesChanged, formatBytes(j.Restic.DataAdded))
+	case j.Rclone != nil:
+		return fmt.Sprintf("%d transfers, %s, %d errors", j.Rclone.Transfers, formatBytes(j.Rclone.Bytes), j.Rclone.Errors)
+	default:
+		return "-"
+	}
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+
+	return id
+}
+
+// formatBytes formats a size with binary prefixes, e.g. 1.5 MiB
+func formatBytes(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
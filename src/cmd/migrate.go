@@ -0,0 +1,64 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/th3noname/backup-and-sync/src/restic"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate <repoID>",
+	Short: "upgrade a restic repository to version 2",
+	Long: `Checks the restic repository with the given id and upgrades it to the
+repository format version 2 which supports compression.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+
+		if !viper.IsSet("restic") {
+			log.Error("no restic configuration found")
+			return
+		}
+
+		var resticConf *restic.Config
+
+		err := viper.UnmarshalKey("restic", &resticConf)
+		if err != nil {
+			log.WithError(err).Error("Unmarshal restic configuration failed")
+			return
+		}
+
+		r := restic.New(resticConf)
+		err = r.Migrate(signalContext(), args[0])
+		if err != nil {
+			log.WithError(err).Error("restic migration failed")
+			return
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
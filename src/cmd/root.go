@@ -21,8 +21,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -67,6 +70,22 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./backup.config)")
 }
 
+// signalContext returns a context that is cancelled when backup-and-sync receives SIGINT or SIGTERM
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.WithField("signal", sig).Warn("Received signal. Stopping running jobs")
+		cancel()
+	}()
+
+	return ctx
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	log.Info("Start reading config file")
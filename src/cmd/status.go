@@ -0,0 +1,220 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/th3noname/backup-and-sync/src/jobs"
+)
+
+var statusJSON bool
+
+// ANSI colours of the status table. All codes have the same length so the columns stay aligned.
+const (
+	colorBold   = "\x1b[01m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorGrey   = "\x1b[90m"
+	colorReset  = "\x1b[0m"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the health of every job",
+	Long: `Shows the last run, last success, consecutive failures, latest snapshot age and
+last transferred size of every restic backup and rclone job and the differences found by
+the last rclone check.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if statusJSON {
+			// keep stdout parsable
+			log.SetOutput(os.Stderr)
+		}
+
+		initConfig()
+
+		var conf jobs.Config
+
+		err := viper.Unmarshal(&conf)
+		if err != nil {
+			log.WithError(err).Error("Unmarshal configuration failed")
+			return
+		}
+
+		runner, err := jobs.New(&conf)
+		if err != nil {
+			log.WithError(err).Error("Loading jobs failed")
+			return
+		}
+
+		statuses, err := runner.Status(signalContext())
+		if err != nil {
+			log.WithError(err).Error("Reading status failed")
+			return
+		}
+
+		if statusJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+
+			err = enc.Encode(statuses)
+			if err != nil {
+				log.WithError(err).Error("Writing status failed")
+			}
+
+			return
+		}
+
+		color := isTerminal(os.Stdout)
+		now := time.Now()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		printRow(w, color, colorBold, "JOB", "TYPE", "LAST RUN", "STATUS", "LAST SUCCESS", "FAILURES", "SNAPSHOT AGE", "TRANSFERRED", "DIFFERENCES")
+
+		for _, s := range statuses {
+			printRow(w, color, statusColor(s), s.ID, s.Type, formatTime(s.LastRun), orDash(s.LastStatus),
+				formatTime(s.LastSuccess), fmt.Sprint(s.ConsecutiveFailures), snapshotAge(s, now), transferred(s), differences(s))
+		}
+
+		w.Flush()
+
+		for _, s := range statuses {
+			if s.Error != "" {
+				fmt.Printf("\n%s: %s\n", s.ID, s.Error)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "print the status as JSON")
+}
+
+// printRow writes a tab separated table row, coloured if color is set
+func printRow(w *tabwriter.Writer, color bool, code string, cells ...string) {
+	if color {
+		fmt.Fprint(w, code)
+	}
+
+	for i, cell := range cells {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+
+		fmt.Fprint(w, cell)
+	}
+
+	if color {
+		fmt.Fprint(w, colorReset)
+	}
+
+	fmt.Fprintln(w)
+}
+
+// isTerminal reports whether f is a terminal and colours are not disabled via NO_COLOR
+func isTerminal(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func statusColor(s jobs.JobStatus) string {
+	switch {
+	case s.Error != "":
+		return colorRed
+	case s.LastStatus == jobs.StatusSuccess:
+		return colorGreen
+	case s.LastStatus == jobs.StatusFailed || s.LastStatus == jobs.StatusTimedOut:
+		return colorRed
+	case s.LastStatus == "":
+		return colorGrey
+	default:
+		return colorYellow
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Local().Format(dateFormat)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// snapshotAge formats the age of the latest snapshot, e.g. "3h" or "2d"
+func snapshotAge(s jobs.JobStatus, now time.Time) string {
+	if s.LatestSnapshot == nil {
+		return "-"
+	}
+
+	age := now.Sub(*s.LatestSnapshot)
+
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+}
+
+func transferred(s jobs.JobStatus) string {
+	if s.LastTransferred == nil {
+		return "-"
+	}
+
+	return formatBytes(*s.LastTransferred)
+}
+
+func differences(s jobs.JobStatus) string {
+	if s.Differences == nil {
+		return "-"
+	}
+
+	return fmt.Sprint(s.Differences.Total())
+}
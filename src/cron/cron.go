@@ -0,0 +1,260 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package cron parses cron expressions and calculates their activation times
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// descriptors are shortcuts for common expressions
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+type field struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{min: 0, max: 59}
+	hourField   = field{min: 0, max: 23}
+	domField    = field{min: 1, max: 31}
+	monthField  = field{min: 1, max: 12, names: monthNames}
+	// 7 is sunday like 0
+	dowField = field{min: 0, max: 7, names: dayNames}
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// restricted day fields are combined with or, like in cron
+	domStar, dowStar bool
+}
+
+// Parse parses a cron expression with the fields minute, hour, day of month, month and day of
+// week. Fields support *, ranges (1-5), steps (*/15, 0-30/10), lists (1,15) and the names of
+// months and week days. The descriptors @yearly, @annually, @monthly, @weekly, @daily,
+// @midnight and @hourly are supported as well.
+func Parse(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if d, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression \"%s\" must have 5 fields", expr)
+	}
+
+	s := &Schedule{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+
+	var err error
+	for i, v := range []struct {
+		bits *uint64
+		f    field
+	}{
+		{&s.minute, minuteField},
+		{&s.hour, hourField},
+		{&s.dom, domField},
+		{&s.month, monthField},
+		{&s.dow, dowField},
+	} {
+		*v.bits, err = parseField(fields[i], v.f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cron expression \"%s\"", expr)
+		}
+	}
+
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+// parseField returns the values of the field as bit set
+func parseField(value string, f field) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(value, ",") {
+		rangePart, step, hasStep := part, 1, false
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+
+			rangePart, hasStep = part[:i], true
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in \"%s\"", part)
+			}
+		}
+
+		start, end := f.min, f.max
+
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+
+			var err error
+			if start, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+
+			if end, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if start, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+
+			// a single value with a step starts at the value and ends at the maximum
+			if !hasStep {
+				end = start
+			}
+		}
+
+		if start > end {
+			return 0, errors.Errorf("invalid range \"%s\"", part)
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("invalid value \"%s\"", s)
+	}
+
+	if v < f.min || v > f.max {
+		return 0, errors.Errorf("value %d out of range %d-%d", v, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// Next returns the first activation time after t in the location of t. The zero time is
+// returned if there is no activation time within the next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if s.skipped(t, next) {
+				return next
+			}
+
+			t = next
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			next := t.Truncate(time.Minute).Add(time.Minute)
+			if s.skipped(t, next) {
+				return next
+			}
+
+			t = next
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// skipped reports whether a scheduled hour between t and next does not exist
+// because the clock was set forward for daylight saving time. Like cron, such
+// activations happen right after the change.
+func (s *Schedule) skipped(t, next time.Time) bool {
+	if next.Day() != t.Day() {
+		return false
+	}
+
+	for h := t.Hour() + 1; h < next.Hour(); h++ {
+		if s.hour&(1<<uint(h)) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
@@ -0,0 +1,119 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@every",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"* * * * mon-",
+	}
+
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %s", err)
+	}
+
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	local := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"step", "*/15 * * * *", utc(2026, 10, 18, 10, 7).Add(30 * time.Second), utc(2026, 10, 18, 10, 15)},
+		{"step wraps hour", "*/15 * * * *", utc(2026, 10, 18, 10, 45), utc(2026, 10, 18, 11, 0)},
+		{"strictly after", "0 10 * * *", utc(2026, 10, 18, 10, 0), utc(2026, 10, 19, 10, 0)},
+		{"hourly", "@hourly", utc(2026, 10, 18, 10, 30), utc(2026, 10, 18, 11, 0)},
+		{"daily", "@daily", utc(2026, 10, 18, 10, 0), utc(2026, 10, 19, 0, 0)},
+		{"midnight", "@midnight", utc(2026, 10, 18, 10, 0), utc(2026, 10, 19, 0, 0)},
+		{"weekly", "@weekly", utc(2026, 10, 18, 10, 0), utc(2026, 10, 25, 0, 0)},
+		{"monthly", "@monthly", utc(2026, 10, 18, 10, 0), utc(2026, 11, 1, 0, 0)},
+		{"yearly", "@yearly", utc(2026, 10, 18, 10, 0), utc(2027, 1, 1, 0, 0)},
+		{"annually", "@annually", utc(2026, 10, 18, 10, 0), utc(2027, 1, 1, 0, 0)},
+		{"names", "0 9 * jan mon-fri", utc(2026, 10, 18, 10, 0), utc(2027, 1, 1, 9, 0)},
+		{"sunday as 7", "0 12 * * 7", utc(2026, 10, 18, 13, 0), utc(2026, 10, 25, 12, 0)},
+		{"leap day", "0 0 29 2 *", utc(2026, 10, 18, 10, 0), utc(2028, 2, 29, 0, 0)},
+		{"impossible date", "0 0 31 2 *", utc(2026, 10, 18, 10, 0), time.Time{}},
+		{"impossible date in short month", "0 0 31 4,6,9,11 *", utc(2026, 10, 18, 10, 0), time.Time{}},
+		{"day of week only", "0 0 * * 1", utc(2026, 10, 18, 10, 0), utc(2026, 10, 19, 0, 0)},
+		{"day of month only", "0 0 13 * *", utc(2026, 10, 18, 10, 0), utc(2026, 11, 13, 0, 0)},
+		{"day of month or week by week", "0 0 13 * 5", utc(2026, 10, 18, 10, 0), utc(2026, 10, 23, 0, 0)},
+		{"day of month or week by month", "0 0 13 * 1", utc(2027, 2, 9, 10, 0), utc(2027, 2, 13, 0, 0)},
+		{"day range and week star", "0 0 1-7 * *", utc(2026, 10, 18, 10, 0), utc(2026, 11, 1, 0, 0)},
+		{"location kept", "0 3 * * *", local(2026, 10, 18, 10, 0), local(2026, 10, 19, 3, 0)},
+		{"dst gap", "30 2 * * *", local(2026, 3, 28, 12, 0), local(2026, 3, 29, 3, 0)},
+		{"dst gap next day", "30 2 * * *", local(2026, 3, 29, 3, 0), local(2026, 3, 30, 2, 30)},
+		{"dst gap step", "*/30 * * * *", local(2026, 3, 29, 1, 45), local(2026, 3, 29, 3, 0)},
+		{"dst gap after", "0 3 * * *", local(2026, 3, 28, 12, 0), local(2026, 3, 29, 3, 0)},
+		{"dst end", "30 2 * * *", local(2026, 10, 25, 0, 0), time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC)},
+		{"dst end next day", "30 2 * * *", time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC).In(berlin), local(2026, 10, 26, 2, 30)},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("%s: Parse(%q) failed: %s", test.name, test.expr, err)
+			continue
+		}
+
+		got := s.Next(test.from)
+		if !got.Equal(test.want) {
+			t.Errorf("%s: Next(%s) = %s, want %s", test.name, test.from, got, test.want)
+		}
+
+		if !got.IsZero() && got.Location() != test.from.Location() {
+			t.Errorf("%s: Next(%s) returned location %s", test.name, test.from, got.Location())
+		}
+	}
+}
@@ -3,8 +3,11 @@ module github.com/th3noname/backup-and-sync/src
 require (
 	github.com/BurntSushi/toml v0.3.1 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/pkg/errors v0.8.1
 	github.com/sirupsen/logrus v1.4.1
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/viper v1.3.2
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/sys v0.0.0-20200923182605-d9f96fdee20d // indirect
 )
@@ -0,0 +1,293 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package history stores the results of all runs in a local database
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/th3noname/backup-and-sync/src/rclone"
+	"github.com/th3noname/backup-and-sync/src/restic"
+	bolt "go.etcd.io/bbolt"
+)
+
+var runsBucket = []byte("runs")
+
+// openTimeout is the time to wait for another process using the database
+const openTimeout = 10 * time.Second
+
+// Run is a recorded run
+type Run struct {
+	ID      uint64    `json:"id"`
+	Trigger string    `json:"trigger"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Jobs    []Job     `json:"jobs"`
+}
+
+// Job is the recorded result of a single job of a run
+type Job struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Status   string          `json:"status"`
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error,omitempty"`
+	Restic   *restic.Summary `json:"restic,omitempty"`
+	Rclone   *rclone.Stats   `json:"rclone,omitempty"`
+	// Differences is the number of differences found by an rclone check or cryptcheck
+	Differences *rclone.DifferenceCount `json:"differences,omitempty"`
+}
+
+// Job returns the result of the job with the id
+func (r *Run) Job(id string) (Job, bool) {
+	for _, j := range r.Jobs {
+		if j.ID == id {
+			return j, true
+		}
+	}
+
+	return Job{}, false
+}
+
+// Filter selects runs. Zero values match every run.
+type Filter struct {
+	// Job selects runs that executed the job
+	Job string
+	// Status is compared with the status of Job if it is set, otherwise with the run status
+	Status string
+	Since  time.Time
+	Until  time.Time
+	// Limit is the maximum number of returned runs
+	Limit int
+}
+
+func (f *Filter) matches(r *Run) bool {
+	if !f.Since.IsZero() && r.Start.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && r.Start.After(f.Until) {
+		return false
+	}
+
+	status := r.Status
+
+	if f.Job != "" {
+		j, ok := r.Job(f.Job)
+		if !ok {
+			return false
+		}
+
+		status = j.Status
+	}
+
+	return f.Status == "" || f.Status == status
+}
+
+// Store is the history database
+type Store struct {
+	db *bolt.DB
+}
+
+// Path returns the path of the database in the state directory
+func Path(stateDir string) string {
+	return filepath.Join(stateDir, "history.db")
+}
+
+// Open opens the database at path and creates it if it does not exist
+func Open(path string) (*Store, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "create history directory failed")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, errors.Wrap(err, "open history database failed")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(runsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "initialize history database failed")
+	}
+
+	return &Store{db: db}, nil
+}
+
+// OpenReadOnly opens an existing database without locking out other readers
+func OpenReadOnly(path string) (*Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, errors.Wrap(err, "open history database failed")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "open history database failed")
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add stores the run and sets its id
+func (s *Store) Add(r *Run) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		r.ID = id
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			return errors.Wrap(err, "encode run failed")
+		}
+
+		return b.Put(key(id), data)
+	})
+}
+
+// Get returns the run with the id
+func (s *Store) Get(id uint64) (*Run, error) {
+	var r *Run
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b == nil {
+			return nil
+		}
+
+		data := b.Get(key(id))
+		if data == nil {
+			return nil
+		}
+
+		r = &Run{}
+		return json.Unmarshal(data, r)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "read run %d failed", id)
+	}
+
+	if r == nil {
+		return nil, errors.Errorf("run %d does not exist", id)
+	}
+
+	return r, nil
+}
+
+// List returns the runs matching the filter, newest first
+func (s *Store) List(f Filter) ([]Run, error) {
+	var runs []Run
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var r Run
+			if err := json.Unmarshal(v, &r); err != nil {
+				return errors.Wrapf(err, "decode run %d failed", binary.BigEndian.Uint64(k))
+			}
+
+			if !f.matches(&r) {
+				continue
+			}
+
+			runs = append(runs, r)
+
+			if f.Limit > 0 && len(runs) >= f.Limit {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return runs, errors.Wrap(err, "read runs failed")
+}
+
+// Prune deletes all runs that started before the provided time and returns their number
+func (s *Store) Prune(before time.Time) (int, error) {
+	count := 0
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+
+		var expired [][]byte
+
+		// runs are stored in the order they were recorded, so the oldest runs come first
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var r Run
+			if err := json.Unmarshal(v, &r); err != nil {
+				return errors.Wrapf(err, "decode run %d failed", binary.BigEndian.Uint64(k))
+			}
+
+			if !r.Start.Before(before) {
+				break
+			}
+
+			expired = append(expired, k)
+		}
+
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		count = len(expired)
+		return nil
+	})
+
+	return count, errors.Wrap(err, "prune history failed")
+}
+
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
@@ -0,0 +1,196 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jobs
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Conditions for the execution of a job depending on the status of the jobs it needs
+const (
+	WhenSuccess = "success"
+	WhenFailure = "failure"
+	WhenAlways  = "always"
+)
+
+// validateGraph checks the ids, needs, conditions and priorities of all jobs
+func validateGraph(jobs []Job) error {
+	ids := make(map[string]bool)
+
+	for _, j := range jobs {
+		if j.ID == "" {
+			return errors.Errorf("%s job has an empty id", j.Type)
+		}
+
+		if ids[j.ID] {
+			return errors.Errorf("job id \"%s\" is used more than once", j.ID)
+		}
+
+		ids[j.ID] = true
+
+		switch j.When {
+		case WhenSuccess, WhenFailure, WhenAlways:
+		default:
+			return errors.Errorf("job \"%s\" has an invalid condition \"%s\". Valid conditions are success, failure and always", j.ID, j.When)
+		}
+
+		switch j.Priority {
+		case PriorityNormal, PriorityLow:
+		default:
+			return errors.Errorf("job \"%s\" has an invalid priority \"%s\". Valid priorities are normal and low", j.ID, j.Priority)
+		}
+	}
+
+	for _, j := range jobs {
+		for _, need := range j.Needs {
+			if !ids[need] {
+				return errors.Errorf("job \"%s\" needs the unknown job \"%s\"", j.ID, need)
+			}
+
+			if need == j.ID {
+				return errors.Errorf("job \"%s\" needs itself", j.ID)
+			}
+		}
+	}
+
+	_, err := order(jobs)
+	return err
+}
+
+// order returns the job indexes sorted so that every job comes after the jobs it needs.
+// Jobs without dependencies between them keep their configured order.
+func order(jobs []Job) ([]int, error) {
+	index := make(map[string]int, len(jobs))
+	for i, j := range jobs {
+		index[j.ID] = i
+	}
+
+	pending := make([]int, len(jobs))
+	dependents := make([][]int, len(jobs))
+
+	for i, j := range jobs {
+		for _, need := range j.Needs {
+			pending[i]++
+			dependents[index[need]] = append(dependents[index[need]], i)
+		}
+	}
+
+	var ready []int
+	for i := range jobs {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	var result []int
+
+	for len(ready) > 0 {
+		sort.Ints(ready)
+
+		i := ready[0]
+		ready = ready[1:]
+		result = append(result, i)
+
+		for _, d := range dependents[i] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if len(result) != len(jobs) {
+		var cycle []string
+		for i, j := range jobs {
+			if pending[i] > 0 {
+				cycle = append(cycle, j.ID)
+			}
+		}
+
+		return nil, errors.Errorf("job dependencies contain a cycle between the jobs %s", strings.Join(cycle, ", "))
+	}
+
+	return result, nil
+}
+
+// predecessors returns for every job the indexes of the jobs before it in the order that access
+// an overlapping path. Without needs between them, such jobs must not run concurrently or in
+// reverse order.
+func predecessors(jobs []Job, jobOrder []int) [][]int {
+	result := make([][]int, len(jobs))
+
+	for pos, i := range jobOrder {
+		for _, k := range jobOrder[:pos] {
+			if overlapping(jobs[k].paths, jobs[i].paths) {
+				result[i] = append(result[i], k)
+			}
+		}
+	}
+
+	return result
+}
+
+func overlapping(a, b []string) bool {
+	for _, p := range a {
+		for _, q := range b {
+			if overlaps(p, q) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// overlaps reports whether two local or rclone paths are equal or one contains the other
+func overlaps(a, b string) bool {
+	a, b = cleanPath(a), cleanPath(b)
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	if a == "" || !strings.HasPrefix(b, a) {
+		return false
+	}
+
+	return len(a) == len(b) || strings.HasSuffix(a, "/") || strings.HasSuffix(a, ":") || b[len(a)] == '/'
+}
+
+// cleanPath normalises the path after the remote name (e.g. "remote:" or a windows drive)
+func cleanPath(p string) string {
+	p = filepath.ToSlash(p)
+
+	prefix := ""
+	if i := strings.Index(p, ":"); i >= 0 {
+		prefix, p = p[:i+1], p[i+1:]
+	}
+
+	if p == "" {
+		return prefix
+	}
+
+	return prefix + path.Clean(p)
+}
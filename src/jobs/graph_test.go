@@ -0,0 +1,146 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package jobs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func job(id string, needs ...string) Job {
+	return Job{ID: id, Type: "restic-backup", Needs: needs, When: WhenSuccess, Priority: PriorityNormal}
+}
+
+func TestValidateGraph(t *testing.T) {
+	invalidWhen := job("a")
+	invalidWhen.When = "sometimes"
+
+	invalidPriority := job("a")
+	invalidPriority.Priority = "high"
+
+	tests := []struct {
+		name string
+		jobs []Job
+		err  string
+	}{
+		{"valid", []Job{job("a"), job("b", "a"), job("c", "a", "b")}, ""},
+		{"empty id", []Job{job("")}, "empty id"},
+		{"duplicate id", []Job{job("a"), job("a")}, "more than once"},
+		{"invalid condition", []Job{invalidWhen}, "invalid condition"},
+		{"invalid priority", []Job{invalidPriority}, "invalid priority"},
+		{"unknown need", []Job{job("a", "b")}, "unknown job"},
+		{"needs itself", []Job{job("a", "a")}, "needs itself"},
+		{"cycle", []Job{job("a", "c"), job("b", "a"), job("c", "b"), job("d")}, "cycle between the jobs a, b, c"},
+	}
+
+	for _, test := range tests {
+		err := validateGraph(test.jobs)
+
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: succeeded, want error containing %q", test.name, test.err)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%s: error %q does not contain %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []Job
+		want []int
+	}{
+		{"configured order", []Job{job("a"), job("b"), job("c")}, []int{0, 1, 2}},
+		{"needs later job", []Job{job("a", "c"), job("b"), job("c")}, []int{1, 2, 0}},
+		{"chain", []Job{job("a", "b"), job("b", "c"), job("c")}, []int{2, 1, 0}},
+		{"independent keep order", []Job{job("a", "d"), job("b"), job("c", "d"), job("d")}, []int{1, 3, 0, 2}},
+	}
+
+	for _, test := range tests {
+		got, err := order(test.jobs)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: order = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/repo", "/repo", true},
+		{"/repo", "/repo/", true},
+		{"/repo/", "/repo/data", true},
+		{"/repo/data", "/repo", true},
+		{"/repo/./data/..", "/repo", true},
+		{"/", "/repo", true},
+		{"/repo", "/repository", false},
+		{"/repo", "/other", false},
+		{"remote:", "remote:path", true},
+		{"remote:path", "remote:path/sub", true},
+		{"remote:path", "remote:pathname", false},
+		{"remote:path", "other:path", false},
+		{"remote:path", "/path", false},
+		{"", "/repo", false},
+	}
+
+	for _, test := range tests {
+		if got := overlaps(test.a, test.b); got != test.want {
+			t.Errorf("overlaps(%q, %q) = %t, want %t", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestPredecessors(t *testing.T) {
+	backup := job("backup")
+	backup.paths = []string{"/repo", "/data"}
+
+	sync := job("sync")
+	sync.paths = []string{"/repo/", "remote:repo"}
+
+	copy := job("copy")
+	copy.paths = []string{"/photos", "remote:photos"}
+
+	check := job("check", "copy")
+	check.paths = []string{"/data", "remote:photos"}
+
+	jobs := []Job{check, backup, sync, copy}
+
+	jobOrder, err := order(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]int{{1, 3}, nil, {1}, nil}
+
+	if got := predecessors(jobs, jobOrder); !reflect.DeepEqual(got, want) {
+		t.Errorf("predecessors = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jobs
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/history"
+	"github.com/th3noname/backup-and-sync/src/statefile"
+)
+
+// HistoryPath returns the path of the history database
+func (c *Config) HistoryPath() string {
+	return history.Path(c.stateDir())
+}
+
+func (c *Config) stateDir() string {
+	if c.StateDir == "" {
+		return statefile.DefaultDir()
+	}
+
+	return c.StateDir
+}
+
+// runStatus returns the status of a whole run. A run is cancelled if ctx was cancelled, failed
+// if a job failed or timed out and finished with a warning if a job finished with a warning.
+func runStatus(ctx context.Context, results []Result, err error) string {
+	counts := make(map[string]int)
+	for _, res := range results {
+		counts[res.Status]++
+	}
+
+	switch {
+	case err != nil && ctx.Err() == context.Canceled:
+		return StatusCancelled
+	case err != nil || counts[StatusFailed] > 0 || counts[StatusTimedOut] > 0:
+		return StatusFailed
+	case counts[StatusWarning] > 0:
+		return StatusWarning
+	default:
+		return StatusSuccess
+	}
+}
+
+// record stores the run in the history and deletes the runs older than the history retention
+func (r *Runner) record(trigger string, start time.Time, status string, runErr error) {
+	run := history.Run{
+		Trigger: trigger,
+		Start:   start,
+		End:     time.Now(),
+		Status:  status,
+	}
+
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+
+	for _, res := range r.results {
+		j := history.Job{
+			ID:       res.ID,
+			Type:     res.Type,
+			Status:   res.Status,
+			Start:    res.Start,
+			End:      res.End,
+			Attempts: res.Attempts,
+			Restic:   res.Restic,
+			Rclone:   res.Rclone,
+		}
+
+		if res.Err != nil {
+			j.Error = res.Err.Error()
+		}
+
+		if res.Differences != nil {
+			j.Differences = res.Differences.Counts()
+		}
+
+		run.Jobs = append(run.Jobs, j)
+	}
+
+	store, err := history.Open(history.Path(r.stateDir))
+	if err != nil {
+		log.WithError(err).Warn("recording run in history failed")
+		return
+	}
+
+	defer store.Close()
+
+	err = store.Add(&run)
+	if err != nil {
+		log.WithError(err).Warn("recording run in history failed")
+		return
+	}
+
+	log.WithField("run", run.ID).Info("run recorded in history")
+
+	if r.retention <= 0 {
+		return
+	}
+
+	count, err := store.Prune(time.Now().Add(-r.retention))
+	if err != nil {
+		log.WithError(err).Warn("pruning history failed")
+		return
+	}
+
+	if count > 0 {
+		log.WithField("runs", count).Info("deleted runs older than the history retention")
+	}
+}
@@ -0,0 +1,942 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/metrics"
+	"github.com/th3noname/backup-and-sync/src/process"
+	"github.com/th3noname/backup-and-sync/src/rclone"
+	"github.com/th3noname/backup-and-sync/src/restic"
+	"github.com/th3noname/backup-and-sync/src/retry"
+)
+
+// Job types of the ordered job list
+const (
+	ResticBackup     = "restic-backup"
+	ResticForget     = "restic-forget"
+	RcloneCopy       = "rclone-copy"
+	RcloneSync       = "rclone-sync"
+	RcloneMove       = "rclone-move"
+	RcloneCheck      = "rclone-check"
+	RcloneCryptCheck = "rclone-cryptcheck"
+	RcloneBisync     = "rclone-bisync"
+)
+
+// Status of an executed job
+const (
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+	StatusCancelled = "cancelled"
+	StatusTimedOut  = "timed-out"
+	StatusWarning   = "warning"
+)
+
+// Priorities of a job. Low priority jobs are skipped if the run is running out of time.
+const (
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// Job is a single entry of the ordered job list
+type Job struct {
+	ID       string
+	Type     string
+	Needs    []string
+	When     string
+	Priority string
+	Schedule *Schedule
+	// Every is the interval after which a job is due in catch-up mode
+	Every time.Duration
+
+	restic restic.Job
+	rclone rclone.Job
+	// paths are accessed by the job. Jobs with overlapping paths keep their order.
+	paths []string
+}
+
+func (j *Job) continueOnError() bool {
+	if j.restic != nil {
+		return restic.ContinueOnError(j.restic)
+	}
+
+	return rclone.ContinueOnError(j.rclone)
+}
+
+func (j *Job) timeout() time.Duration {
+	if j.restic != nil {
+		return restic.Timeout(j.restic)
+	}
+
+	return rclone.Timeout(j.rclone)
+}
+
+func (j *Job) retryPolicy() retry.Policy {
+	if j.restic != nil {
+		return restic.RetryPolicy(j.restic)
+	}
+
+	return rclone.RetryPolicy(j.rclone)
+}
+
+// Result contains the outcome of a single job
+type Result struct {
+	ID       string
+	Type     string
+	Status   string
+	Start    time.Time
+	End      time.Time
+	Attempts int
+	Err      error
+	// Restic contains the statistics of a restic backup
+	Restic *restic.Summary
+	// Rclone contains the transfer statistics of an rclone job
+	Rclone *rclone.Stats
+	// Differences contains the files reported by an rclone check or cryptcheck
+	Differences *rclone.Differences
+}
+
+// Config contains the settings of a run
+type Config struct {
+	Restic      *restic.Config           `mapstructure:"restic"`
+	Rclone      *rclone.Config           `mapstructure:"rclone"`
+	Jobs        []map[string]interface{} `mapstructure:"jobs"`
+	MaxParallel int                      `mapstructure:"max-parallel"`
+	GracePeriod time.Duration            `mapstructure:"shutdown-grace-period"`
+	MaxRuntime  time.Duration            `mapstructure:"max-runtime"`
+	Schedules   []ScheduleGroup          `mapstructure:"schedules"`
+	StateDir    string                   `mapstructure:"state-dir"`
+	// HistoryRetention is the time runs are kept in the history. Zero keeps all runs.
+	HistoryRetention time.Duration  `mapstructure:"history-retention"`
+	Metrics          metrics.Config `mapstructure:"metrics"`
+}
+
+// Runner executes restic and rclone jobs in the configured order
+type Runner struct {
+	resticConf  *restic.Config
+	rcloneConf  *rclone.Config
+	restic      restic.Restic
+	rclone      rclone.Rclone
+	jobs        []Job
+	triggers    []Trigger
+	stateDir    string
+	retention   time.Duration
+	maxParallel int
+	maxRuntime  time.Duration
+	limits      map[string]int
+	results     []Result
+	metricsConf metrics.Config
+	metrics     *metrics.Handler
+	// serving is set once the metrics are served by the daemon
+	serving bool
+}
+
+// New creates a Runner. The jobs are decoded from the jobs list. If there is no jobs list the
+// jobs of the restic and rclone configurations are run: all restic backups, restic forgets and
+// afterwards the rclone copy, sync, move, check, cryptcheck and bisync jobs.
+func New(conf *Config) (*Runner, error) {
+	resticConf := conf.Restic
+	if resticConf == nil {
+		resticConf = &restic.Config{}
+	}
+
+	rcloneConf := conf.Rclone
+	if rcloneConf == nil {
+		rcloneConf = &rclone.Config{}
+	}
+
+	stateDir := conf.stateDir()
+
+	if rcloneConf.StateDir == "" {
+		rcloneConf.StateDir = stateDir
+	}
+
+	err := rcloneConf.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "rclone configuration invalid")
+	}
+
+	var jobs []Job
+
+	if len(conf.Jobs) == 0 {
+		jobs = defaultJobs(resticConf, rcloneConf)
+	} else {
+		if len(resticConf.Jobs()) > 0 || len(rcloneConf.Jobs()) > 0 {
+			return nil, errors.New("jobs can not be combined with the job lists of the restic and rclone configuration")
+		}
+
+		jobs, err = decode(conf.Jobs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rs := restic.New(resticConf)
+
+	for i, j := range jobs {
+		if j.restic != nil {
+			jobs[i].paths = rs.Paths(j.restic)
+		} else {
+			jobs[i].paths = rclone.Paths(j.rclone)
+		}
+	}
+
+	for _, j := range jobs {
+		err = j.retryPolicy().Validate()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid job \"%s\"", j.ID)
+		}
+
+		if j.rclone == nil {
+			continue
+		}
+
+		err = rcloneConf.ValidateJob(j.rclone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid job \"%s\"", j.ID)
+		}
+	}
+
+	err = validateGraph(jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	triggers, err := newTriggers(jobs, conf.Schedules)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.GracePeriod > 0 {
+		process.GracePeriod = conf.GracePeriod
+	}
+
+	if conf.MaxParallel < 0 {
+		return nil, errors.New("max-parallel must not be negative")
+	}
+
+	if conf.HistoryRetention < 0 {
+		return nil, errors.New("history-retention must not be negative")
+	}
+
+	if conf.MaxRuntime < 0 {
+		return nil, errors.New("max-runtime must not be negative")
+	}
+
+	maxParallel := conf.MaxParallel
+	if maxParallel == 0 {
+		maxParallel = 1
+	}
+
+	used := make(map[string]bool)
+	for _, j := range jobs {
+		if j.rclone == nil {
+			continue
+		}
+
+		for _, remote := range rclone.Remotes(j.rclone) {
+			used[remoteResource(remote)] = true
+		}
+	}
+
+	limits := make(map[string]int)
+	for name, limit := range rcloneConf.RemoteLimits {
+		if limit < 0 {
+			return nil, errors.Errorf("limit of remote \"%s\" must not be negative", name)
+		}
+
+		// a limit of a misspelled remote would be ignored silently
+		if !used[remoteResource(name)] {
+			return nil, errors.Errorf("limit of remote \"%s\" is set, but no job uses the remote", name)
+		}
+
+		limits[remoteResource(name)] = limit
+	}
+
+	return &Runner{
+		resticConf:  resticConf,
+		rcloneConf:  rcloneConf,
+		jobs:        jobs,
+		triggers:    triggers,
+		stateDir:    stateDir,
+		retention:   conf.HistoryRetention,
+		maxParallel: maxParallel,
+		maxRuntime:  conf.MaxRuntime,
+		limits:      limits,
+		metricsConf: conf.Metrics,
+		metrics:     metrics.NewHandler(),
+	}, nil
+}
+
+type completion struct {
+	index    int
+	start    time.Time
+	end      time.Time
+	attempts int
+	err      error
+	restic   *restic.Summary
+	rclone   *rclone.Stats
+	// differences are reported by rclone check and cryptcheck
+	differences *rclone.Differences
+	// ctxErr is the error of the job context if the job failed
+	ctxErr error
+}
+
+// Run executes all jobs. A job is started after all jobs it needs are finished and only if its
+// condition is met, otherwise it is skipped. If a job without continue-on-error fails, only
+// jobs with the condition failure or always are started afterwards.
+//
+// Up to max-parallel jobs are executed at the same time. Jobs using the same restic repository
+// never run concurrently and the number of jobs per rclone remote can be limited. A job does not
+// start before the earlier jobs accessing an overlapping path or the same repository are
+// finished, so e.g. a backup into a repository and a sync of the repository keep their order.
+//
+// If ctx is cancelled the running jobs are terminated and recorded as cancelled, all jobs that
+// were not started yet are skipped. Jobs exceeding their timeout are terminated and recorded as
+// timed out. After a job timed out or if the remaining time of max-runtime is shorter than
+// their timeout, low priority jobs are skipped. When max-runtime is exceeded all running jobs
+// time out and the remaining jobs are skipped.
+func (r *Runner) Run(ctx context.Context) error {
+	return r.run(ctx, "backup", r.jobs)
+}
+
+// RunJobs executes the jobs with the provided ids like Run. Needed jobs that are not part of ids
+// are not executed and do not affect the execution. The trigger is recorded in the history.
+func (r *Runner) RunJobs(ctx context.Context, trigger string, ids []string) error {
+	selected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	var jobs []Job
+
+	for _, j := range r.jobs {
+		if !selected[j.ID] {
+			continue
+		}
+
+		var needs []string
+		for _, need := range j.Needs {
+			if selected[need] {
+				needs = append(needs, need)
+			}
+		}
+
+		j.Needs = needs
+		jobs = append(jobs, j)
+	}
+
+	return r.run(ctx, trigger, jobs)
+}
+
+// run executes the jobs with new restic and rclone instances, so no state is kept between runs.
+// The run is recorded in the history.
+func (r *Runner) run(ctx context.Context, trigger string, jobs []Job) error {
+	st, err := loadState(r.stateDir)
+	if err != nil {
+		return errors.Wrap(err, "load job state failed")
+	}
+
+	r.restic = restic.New(r.resticConf)
+	r.rclone = rclone.New(r.rcloneConf)
+	r.results = nil
+
+	start := time.Now()
+
+	err = r.execute(ctx, jobs, st)
+
+	r.logSummary()
+	r.record(trigger, start, runStatus(ctx, r.results, err), err)
+	r.ExportMetrics(ctx)
+
+	return err
+}
+
+// execute runs the jobs in the order of the dependency graph
+func (r *Runner) execute(ctx context.Context, jobs []Job, st *state) error {
+	defer r.rclone.Stop()
+
+	if r.maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.maxRuntime)
+		defer cancel()
+	}
+
+	jobOrder, err := order(jobs)
+	if err != nil {
+		return err
+	}
+
+	earlier := predecessors(jobs, jobOrder)
+
+	status := make(map[string]string, len(jobs))
+	started := make([]bool, len(jobs))
+	inUse := make(map[string]int)
+	done := make(chan completion)
+
+	var runErr error
+
+	timedOut := false
+	running := 0
+	finished := 0
+	count := 0
+
+	for finished < len(jobs) {
+		progress := true
+
+		// skipping a job can make dependent jobs ready, so repeat until nothing changes
+		for progress {
+			progress = false
+
+			for _, i := range jobOrder {
+				j := jobs[i]
+
+				if started[i] || !needsFinished(j, status) || !allFinished(jobs, earlier[i], status) {
+					continue
+				}
+
+				fields := log.Fields{"id": j.ID, "type": j.Type}
+
+				if reason := r.skipReason(ctx, j, status, runErr != nil, timedOut); reason != "" {
+					count++
+					log.WithFields(fields).Infof("skip job %d of %d: %s", count, len(jobs), reason)
+
+					if ctx.Err() != nil && runErr == nil {
+						runErr = stopError(ctx)
+					}
+
+					started[i] = true
+					finished++
+					progress = true
+
+					status[j.ID] = StatusSkipped
+					r.results = append(r.results, Result{ID: j.ID, Type: j.Type, Status: StatusSkipped})
+					continue
+				}
+
+				if running >= r.maxParallel || !r.acquire(j, inUse) {
+					continue
+				}
+
+				count++
+				log.WithFields(fields).Infof("start job %d of %d", count, len(jobs))
+
+				started[i] = true
+				running++
+				progress = true
+
+				go func(i int, j Job) {
+					done <- r.runJob(ctx, i, j)
+				}(i, j)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		c := <-done
+		j := jobs[c.index]
+		fields := log.Fields{"id": j.ID, "type": j.Type}
+
+		running--
+		finished++
+		r.release(j, inUse)
+
+		res := Result{
+			ID:          j.ID,
+			Type:        j.Type,
+			Status:      StatusSuccess,
+			Start:       c.start,
+			End:         c.end,
+			Attempts:    c.attempts,
+			Err:         c.err,
+			Restic:      c.restic,
+			Rclone:      c.rclone,
+			Differences: c.differences,
+		}
+
+		if c.err != nil && restic.IsBlocked(c.err) {
+			res.Status = StatusSkipped
+			log.WithError(c.err).WithFields(fields).Warn("job skipped")
+		} else if c.err != nil && retry.ClassOf(c.err) == retry.Warning {
+			res.Status = StatusWarning
+			log.WithError(c.err).WithFields(fields).Warn("job finished with a warning")
+		} else if c.err != nil && c.ctxErr == context.Canceled {
+			res.Status = StatusCancelled
+			log.WithError(c.err).WithFields(fields).Warn("job cancelled")
+
+			if runErr == nil {
+				runErr = stopError(ctx)
+			}
+		} else if c.err != nil {
+			res.Status = StatusFailed
+
+			if c.ctxErr == context.DeadlineExceeded {
+				res.Status = StatusTimedOut
+				timedOut = true
+			}
+
+			if j.continueOnError() {
+				log.WithError(c.err).WithFields(fields).Warnf("job %s. Continuing...", res.Status)
+			} else if runErr == nil {
+				log.WithError(c.err).WithFields(fields).Errorf("job %s. Skipping remaining jobs", res.Status)
+				runErr = errors.Wrapf(c.err, "%s job \"%s\" %s", j.Type, j.ID, res.Status)
+			} else {
+				log.WithError(c.err).WithFields(fields).Errorf("job %s", res.Status)
+			}
+		} else {
+			log.WithFields(fields).Info("job finished successfully")
+		}
+
+		status[j.ID] = res.Status
+		r.results = append(r.results, res)
+
+		if res.Status == StatusSkipped {
+			continue
+		}
+
+		success := res.Status == StatusSuccess || res.Status == StatusWarning
+		if err := st.record(j.ID, c.start, success); err != nil {
+			log.WithError(err).WithFields(fields).Warn("saving job state failed")
+		}
+	}
+
+	return runErr
+}
+
+// runJob executes the job and retries it after retryable errors according to its retry policy
+func (r *Runner) runJob(ctx context.Context, index int, j Job) completion {
+	policy := j.retryPolicy()
+	fields := log.Fields{"id": j.ID, "type": j.Type}
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		c := r.runAttempt(ctx, index, j)
+		c.start = start
+		c.end = time.Now()
+		c.attempts = attempt
+
+		if c.err == nil || c.ctxErr != nil || attempt > policy.Retries || retry.ClassOf(c.err) != retry.Retryable {
+			return c
+		}
+
+		log.WithError(c.err).WithFields(fields).Warnf("attempt %d of %d failed. Retrying...", attempt, policy.Retries+1)
+
+		if err := policy.Wait(ctx, attempt); err != nil {
+			c.ctxErr = err
+			return c
+		}
+	}
+}
+
+// runAttempt executes the job once with its timeout
+func (r *Runner) runAttempt(ctx context.Context, index int, j Job) completion {
+	if t := j.timeout(); t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+
+	c := completion{index: index}
+
+	var err error
+	if j.restic != nil {
+		var res restic.Result
+		res, err = r.restic.RunJob(ctx, j.restic)
+		c.restic = res.Summary
+	} else {
+		var res rclone.Result
+		res, err = r.rclone.RunJob(ctx, j.rclone)
+		c.rclone = res.Stats
+		c.differences = res.Differences
+	}
+
+	c.err = err
+	if err != nil {
+		c.ctxErr = ctx.Err()
+	}
+
+	return c
+}
+
+// stopError returns the error of a run that was stopped by its context
+func stopError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New("max-runtime exceeded")
+	}
+
+	return errors.Wrap(ctx.Err(), "run cancelled")
+}
+
+// logSummary logs the status of every job of the run
+func (r *Runner) logSummary() {
+	counts := make(map[string]int)
+
+	for _, res := range r.results {
+		counts[res.Status]++
+
+		entry := log.WithFields(log.Fields{"id": res.ID, "type": res.Type, "status": res.Status, "attempts": res.Attempts})
+		if res.Err != nil {
+			entry = entry.WithError(res.Err)
+		}
+
+		entry.Info("job summary")
+	}
+
+	log.WithFields(log.Fields{
+		StatusSuccess:   counts[StatusSuccess],
+		StatusFailed:    counts[StatusFailed],
+		StatusSkipped:   counts[StatusSkipped],
+		StatusCancelled: counts[StatusCancelled],
+		StatusTimedOut:  counts[StatusTimedOut],
+		StatusWarning:   counts[StatusWarning],
+	}).Info("run finished")
+}
+
+// needsFinished reports whether all jobs needed by j are finished
+func needsFinished(j Job, status map[string]string) bool {
+	for _, need := range j.Needs {
+		if _, finished := status[need]; !finished {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allFinished reports whether the jobs with the indexes are finished or skipped
+func allFinished(jobs []Job, indexes []int, status map[string]string) bool {
+	for _, i := range indexes {
+		if _, finished := status[jobs[i].ID]; !finished {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resources returns the resources the job uses. Restic repositories can only be used by one job.
+func (r *Runner) resources(j Job) []string {
+	if j.restic != nil {
+		return []string{repositoryResource(restic.RepositoryID(j.restic))}
+	}
+
+	var resources []string
+	for _, remote := range rclone.Remotes(j.rclone) {
+		resources = append(resources, remoteResource(remote))
+	}
+
+	return resources
+}
+
+func (r *Runner) limit(resource string) int {
+	if strings.HasPrefix(resource, "repository:") {
+		return 1
+	}
+
+	return r.limits[resource]
+}
+
+// acquire reserves all resources of the job if they are available
+func (r *Runner) acquire(j Job, inUse map[string]int) bool {
+	resources := r.resources(j)
+
+	for _, v := range resources {
+		if limit := r.limit(v); limit > 0 && inUse[v] >= limit {
+			return false
+		}
+	}
+
+	for _, v := range resources {
+		inUse[v]++
+	}
+
+	return true
+}
+
+func (r *Runner) release(j Job, inUse map[string]int) {
+	for _, v := range r.resources(j) {
+		inUse[v]--
+	}
+}
+
+func repositoryResource(id string) string {
+	return "repository:" + id
+}
+
+// remoteResource returns the resource of a remote. Remote names are compared case-insensitively
+// because the configuration keys are lowercased.
+func remoteResource(name string) string {
+	return "remote:" + strings.ToLower(name)
+}
+
+// skipReason returns why the job is not executed or an empty string if it should be executed
+func (r *Runner) skipReason(ctx context.Context, j Job, status map[string]string, aborted, timedOut bool) string {
+	switch ctx.Err() {
+	case context.Canceled:
+		return "run cancelled"
+	case context.DeadlineExceeded:
+		return "max-runtime exceeded"
+	}
+
+	if j.Priority == PriorityLow {
+		if timedOut {
+			return "low priority and a previous job timed out"
+		}
+
+		deadline, ok := ctx.Deadline()
+		if ok && j.timeout() > 0 && time.Until(deadline) < j.timeout() {
+			return "low priority and the remaining run time is shorter than its timeout"
+		}
+	}
+
+	if aborted && j.When == WhenSuccess {
+		return "a previous job failed"
+	}
+
+	failed := false
+	succeeded := true
+
+	for _, need := range j.Needs {
+		switch status[need] {
+		case StatusFailed, StatusTimedOut:
+			failed = true
+			succeeded = false
+		case StatusSkipped:
+			succeeded = false
+		}
+	}
+
+	switch j.When {
+	case WhenFailure:
+		if !failed {
+			return "no needed job failed"
+		}
+	case WhenSuccess:
+		if !succeeded {
+			return "a needed job failed or was skipped"
+		}
+	}
+
+	return ""
+}
+
+// Due returns the ids of all jobs with an interval whose last successful run is older than
+// the interval
+func (r *Runner) Due(now time.Time) ([]string, error) {
+	st, err := loadState(r.stateDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "load job state failed")
+	}
+
+	var ids []string
+
+	for _, j := range r.jobs {
+		if j.Every <= 0 {
+			continue
+		}
+
+		last, ok := st.lastSuccess(j.ID)
+		if !ok || now.Sub(last) >= j.Every {
+			ids = append(ids, j.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+// Jobs returns the ids of all jobs
+func (r *Runner) Jobs() []string {
+	ids := make([]string, len(r.jobs))
+	for i, j := range r.jobs {
+		ids[i] = j.ID
+	}
+
+	return ids
+}
+
+// HasIntervals reports whether a job has an interval
+func (r *Runner) HasIntervals() bool {
+	for _, j := range r.jobs {
+		if j.Every > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func defaultJobs(resticConf *restic.Config, rcloneConf *rclone.Config) []Job {
+	var jobs []Job
+
+	for _, v := range resticConf.Jobs() {
+		jobs = append(jobs, Job{Type: resticType(v), When: WhenSuccess, Priority: PriorityNormal, restic: v})
+	}
+
+	for _, v := range rcloneConf.Jobs() {
+		jobs = append(jobs, Job{Type: rcloneType(v), When: WhenSuccess, Priority: PriorityNormal, rclone: v})
+	}
+
+	for i := range jobs {
+		jobs[i].ID = defaultID(jobs[i].Type, i)
+	}
+
+	return jobs
+}
+
+// defaultID returns the id of a job that has no id configured
+func defaultID(jobType string, index int) string {
+	return fmt.Sprintf("%s-%d", jobType, index+1)
+}
+
+func resticType(j restic.Job) string {
+	switch j.(type) {
+	case *restic.Backup:
+		return ResticBackup
+	default:
+		return ResticForget
+	}
+}
+
+func rcloneType(j rclone.Job) string {
+	switch j.(type) {
+	case *rclone.Copy:
+		return RcloneCopy
+	case *rclone.Sync:
+		return RcloneSync
+	case *rclone.Move:
+		return RcloneMove
+	case *rclone.Check:
+		return RcloneCheck
+	case *rclone.CryptCheck:
+		return RcloneCryptCheck
+	default:
+		return RcloneBisync
+	}
+}
+
+// decode creates the jobs of the ordered job list
+func decode(entries []map[string]interface{}) ([]Job, error) {
+	var jobs []Job
+
+	for i, entry := range entries {
+		var meta struct {
+			ID       string        `mapstructure:"id"`
+			Type     string        `mapstructure:"type"`
+			Needs    []string      `mapstructure:"needs"`
+			When     string        `mapstructure:"when"`
+			Priority string        `mapstructure:"priority"`
+			Schedule *Schedule     `mapstructure:"schedule"`
+			Every    time.Duration `mapstructure:"every"`
+		}
+
+		err := decodeEntry(entry, &meta)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode job %d failed", i+1)
+		}
+
+		t := meta.Type
+
+		j := Job{ID: meta.ID, Type: t, Needs: meta.Needs, When: meta.When, Priority: meta.Priority, Schedule: meta.Schedule, Every: meta.Every}
+
+		if j.ID == "" {
+			j.ID = defaultID(t, i)
+		}
+
+		if j.When == "" {
+			j.When = WhenSuccess
+		}
+
+		if j.Priority == "" {
+			j.Priority = PriorityNormal
+		}
+
+		if j.Every < 0 {
+			return nil, errors.Errorf("job %d has a negative interval", i+1)
+		}
+
+		var target interface{}
+
+		switch t {
+		case ResticBackup:
+			v := &restic.Backup{}
+			j.restic, target = v, v
+		case ResticForget:
+			v := &restic.Forget{}
+			j.restic, target = v, v
+		case RcloneCopy:
+			v := &rclone.Copy{}
+			j.rclone, target = v, v
+		case RcloneSync:
+			v := &rclone.Sync{}
+			j.rclone, target = v, v
+		case RcloneMove:
+			v := &rclone.Move{}
+			j.rclone, target = v, v
+		case RcloneCheck:
+			v := &rclone.Check{}
+			j.rclone, target = v, v
+		case RcloneCryptCheck:
+			v := &rclone.CryptCheck{}
+			j.rclone, target = v, v
+		case RcloneBisync:
+			v := &rclone.Bisync{}
+			j.rclone, target = v, v
+		default:
+			return nil, errors.Errorf("job %d has an invalid type \"%s\"", i+1, t)
+		}
+
+		err = decodeEntry(entry, target)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode job %d failed", i+1)
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// decodeEntry decodes a job entry with the same settings viper uses
+func decodeEntry(entry map[string]interface{}, target interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           target,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(entry)
+}
@@ -0,0 +1,123 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jobs
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/metrics"
+	"github.com/th3noname/backup-and-sync/src/restic"
+)
+
+// ServeMetrics serves the metrics of the last run on addr until ctx is cancelled. From then on
+// the metrics are collected after every run.
+func (r *Runner) ServeMetrics(ctx context.Context, addr string) error {
+	err := metrics.Serve(ctx, addr, r.metrics)
+	if err != nil {
+		return err
+	}
+
+	r.serving = true
+	return nil
+}
+
+// ExportMetrics computes the metrics of every job from the run history and the repositories.
+// The metrics are written to the textfile directory and served by the metrics handler. Nothing
+// is collected if there is no textfile directory and the metrics are not served, which saves
+// querying the repositories after every run.
+func (r *Runner) ExportMetrics(ctx context.Context) {
+	if r.metricsConf.TextfileDir == "" && !r.serving {
+		return
+	}
+
+	jobs, err := r.collectMetrics(ctx)
+	if err != nil {
+		log.WithError(err).Warn("collecting metrics failed")
+		return
+	}
+
+	err = r.metrics.Update(jobs)
+	if err != nil {
+		log.WithError(err).Warn("updating metrics failed")
+	}
+
+	if r.metricsConf.TextfileDir == "" {
+		return
+	}
+
+	err = metrics.WriteFile(r.metricsConf.TextfileDir, jobs)
+	if err != nil {
+		log.WithError(err).Warn("writing metrics file failed")
+		return
+	}
+
+	log.WithField("directory", r.metricsConf.TextfileDir).Info("metrics written")
+}
+
+// collectMetrics returns the metrics of every job. The last run of a job is its latest result
+// in the history that was not skipped.
+func (r *Runner) collectMetrics(ctx context.Context) ([]metrics.Job, error) {
+	summaries, err := r.summarizeHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]metrics.Job, len(r.jobs))
+	index := make(map[string]int, len(r.jobs))
+
+	for i, j := range r.jobs {
+		m := metrics.Job{ID: j.ID, Type: j.Type}
+
+		if sum, ok := summaries[j.ID]; ok {
+			m.Status = sum.last.Status
+			m.Start = sum.last.Start
+			m.End = sum.last.End
+
+			if sum.lastSuccess != nil {
+				m.LastSuccess = sum.lastSuccess.Start
+			}
+
+			if sum.lastStats != nil {
+				m.Restic = sum.lastStats.Restic
+				m.Rclone = sum.lastStats.Rclone
+			}
+		}
+
+		result[i] = m
+		index[j.ID] = i
+	}
+
+	r.backupSnapshots(ctx, func(id string, snapshots []restic.Snapshot, err error) {
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).WithField("job", id).Warn("counting snapshots failed")
+			}
+
+			return
+		}
+
+		count := len(snapshots)
+		result[index[id]].Snapshots = &count
+	})
+
+	return result, nil
+}
@@ -0,0 +1,144 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jobs
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/th3noname/backup-and-sync/src/cron"
+)
+
+// Schedule configures when the daemon runs a job or a group of jobs
+type Schedule struct {
+	Cron string `mapstructure:"cron"`
+	// Timezone is the IANA name of the time zone of the cron expression. Default: local time
+	Timezone string `mapstructure:"timezone"`
+	// Jitter delays every run by a random duration up to the jitter
+	Jitter time.Duration `mapstructure:"jitter"`
+}
+
+// ScheduleGroup is a schedule shared by several jobs
+type ScheduleGroup struct {
+	Name     string   `mapstructure:"name"`
+	Jobs     []string `mapstructure:"jobs"`
+	Schedule Schedule `mapstructure:",squash"`
+}
+
+// Trigger starts the jobs of a schedule
+type Trigger struct {
+	Name string
+	Jobs []string
+
+	cron     *cron.Schedule
+	location *time.Location
+	jitter   time.Duration
+}
+
+// Next returns the first activation time of the trigger after the provided time. The zero time
+// is returned if the trigger never activates.
+func (t *Trigger) Next(after time.Time) time.Time {
+	return t.cron.Next(after.In(t.location))
+}
+
+// Jitter returns a random delay for a single run of the trigger
+func (t *Trigger) Jitter() time.Duration {
+	if t.jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(t.jitter)))
+}
+
+// Triggers returns the triggers of all scheduled jobs and schedule groups
+func (r *Runner) Triggers() []Trigger {
+	return r.triggers
+}
+
+func newTrigger(name string, ids []string, s Schedule) (Trigger, error) {
+	if s.Jitter < 0 {
+		return Trigger{}, errors.Errorf("schedule \"%s\" has a negative jitter", name)
+	}
+
+	c, err := cron.Parse(s.Cron)
+	if err != nil {
+		return Trigger{}, errors.Wrapf(err, "invalid schedule \"%s\"", name)
+	}
+
+	location := time.Local
+
+	if s.Timezone != "" {
+		location, err = time.LoadLocation(s.Timezone)
+		if err != nil {
+			return Trigger{}, errors.Wrapf(err, "schedule \"%s\" has an invalid timezone", name)
+		}
+	}
+
+	return Trigger{Name: name, Jobs: ids, cron: c, location: location, jitter: s.Jitter}, nil
+}
+
+// newTriggers creates the triggers of the job schedules and the schedule groups
+func newTriggers(jobs []Job, groups []ScheduleGroup) ([]Trigger, error) {
+	var triggers []Trigger
+
+	ids := make(map[string]bool, len(jobs))
+
+	for _, j := range jobs {
+		ids[j.ID] = true
+
+		if j.Schedule == nil {
+			continue
+		}
+
+		t, err := newTrigger(j.ID, []string{j.ID}, *j.Schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		triggers = append(triggers, t)
+	}
+
+	for i, g := range groups {
+		if g.Name == "" {
+			return nil, errors.Errorf("schedule %d has no name", i+1)
+		}
+
+		if len(g.Jobs) == 0 {
+			return nil, errors.Errorf("schedule \"%s\" has no jobs", g.Name)
+		}
+
+		for _, id := range g.Jobs {
+			if !ids[id] {
+				return nil, errors.Errorf("schedule \"%s\" contains the unknown job \"%s\"", g.Name, id)
+			}
+		}
+
+		t, err := newTrigger(g.Name, g.Jobs, g.Schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		triggers = append(triggers, t)
+	}
+
+	return triggers, nil
+}
@@ -0,0 +1,94 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jobs
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/th3noname/backup-and-sync/src/statefile"
+)
+
+// state is persisted between runs in the state directory
+type state struct {
+	path  string
+	mutex sync.Mutex
+
+	// LastRun contains the start time of the last execution of every job
+	LastRun map[string]time.Time `json:"last-run"`
+	// LastSuccess contains the start time of the last successful execution of every job
+	LastSuccess map[string]time.Time `json:"last-success"`
+}
+
+// loadState reads the job state from dir. A missing state file results in an empty state.
+func loadState(dir string) (*state, error) {
+	if dir == "" {
+		dir = statefile.DefaultDir()
+	}
+
+	s := &state{
+		path:        filepath.Join(dir, "job-state.json"),
+		LastRun:     make(map[string]time.Time),
+		LastSuccess: make(map[string]time.Time),
+	}
+
+	err := statefile.Read(s.path, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.LastRun == nil {
+		s.LastRun = make(map[string]time.Time)
+	}
+
+	if s.LastSuccess == nil {
+		s.LastSuccess = make(map[string]time.Time)
+	}
+
+	return s, nil
+}
+
+func (s *state) lastSuccess(id string) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t, ok := s.LastSuccess[id]
+	return t, ok
+}
+
+// record stores the start time of a finished job and saves the state
+func (s *state) record(id string, start time.Time, success bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.LastRun[id] = start
+	if success {
+		s.LastSuccess[id] = start
+	}
+
+	return s.save()
+}
+
+// save writes the state atomically
+func (s *state) save() error {
+	return statefile.Write(s.path, s)
+}
@@ -0,0 +1,209 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jobs
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/th3noname/backup-and-sync/src/history"
+	"github.com/th3noname/backup-and-sync/src/rclone"
+	"github.com/th3noname/backup-and-sync/src/restic"
+)
+
+// JobStatus summarises the health of a job
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	LastRun    *time.Time `json:"last-run,omitempty"`
+	LastStatus string     `json:"last-status,omitempty"`
+	// LastSuccess is the start of the last run that succeeded or finished with a warning
+	LastSuccess *time.Time `json:"last-success,omitempty"`
+	// ConsecutiveFailures counts the failed or timed out runs since the last success
+	ConsecutiveFailures int `json:"consecutive-failures"`
+	// LatestSnapshot is the time of the latest snapshot of a restic backup
+	LatestSnapshot *time.Time `json:"latest-snapshot,omitempty"`
+	// LastTransferred is the number of bytes added by restic or transferred by rclone in the
+	// last run with statistics
+	LastTransferred *int64 `json:"last-transferred,omitempty"`
+	// Differences is the number of differences found by the last rclone check or cryptcheck
+	// that compared the files
+	Differences *rclone.DifferenceCount `json:"differences,omitempty"`
+	// Error is set if the repository of a restic backup could not be queried
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns the status of every restic backup and rclone job from the run history. The
+// latest snapshot of every restic backup is queried from its repository.
+func (r *Runner) Status(ctx context.Context) ([]JobStatus, error) {
+	summaries, err := r.summarizeHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []JobStatus
+
+	index := make(map[string]int)
+
+	for _, j := range r.jobs {
+		if j.Type == ResticForget {
+			continue
+		}
+
+		s := JobStatus{ID: j.ID, Type: j.Type}
+
+		if sum, ok := summaries[j.ID]; ok {
+			s.LastRun = &sum.last.Start
+			s.LastStatus = sum.last.Status
+			s.ConsecutiveFailures = sum.failures
+
+			if sum.lastSuccess != nil {
+				s.LastSuccess = &sum.lastSuccess.Start
+			}
+
+			if sum.lastStats != nil {
+				if sum.lastStats.Restic != nil {
+					s.LastTransferred = &sum.lastStats.Restic.DataAdded
+				} else {
+					s.LastTransferred = &sum.lastStats.Rclone.Bytes
+				}
+			}
+
+			if sum.lastCheck != nil {
+				s.Differences = sum.lastCheck.Differences
+			}
+		}
+
+		index[j.ID] = len(statuses)
+		statuses = append(statuses, s)
+	}
+
+	r.backupSnapshots(ctx, func(id string, snapshots []restic.Snapshot, err error) {
+		s := &statuses[index[id]]
+
+		if err != nil {
+			s.Error = err.Error()
+			return
+		}
+
+		if len(snapshots) > 0 {
+			s.LatestSnapshot = &snapshots[len(snapshots)-1].Time
+		}
+	})
+
+	return statuses, nil
+}
+
+// backupSnapshots queries the snapshots of every restic backup job from its repository and
+// calls fn with the result
+func (r *Runner) backupSnapshots(ctx context.Context, fn func(id string, snapshots []restic.Snapshot, err error)) {
+	rs := restic.New(r.resticConf)
+
+	for _, j := range r.jobs {
+		b, ok := j.restic.(*restic.Backup)
+		if !ok {
+			continue
+		}
+
+		snapshots, err := rs.Snapshots(ctx, b)
+		fn(j.ID, snapshots, err)
+	}
+}
+
+// jobSummary summarises the recorded runs of a job. Skipped runs are ignored.
+type jobSummary struct {
+	last *history.Job
+	// lastSuccess is the latest run that succeeded or finished with a warning
+	lastSuccess *history.Job
+	// failures counts the failed or timed out runs since the last success
+	failures int
+	// lastStats is the latest run with restic or rclone statistics
+	lastStats *history.Job
+	// lastCheck is the latest check or cryptcheck that compared the files
+	lastCheck *history.Job
+}
+
+// summarizeHistory returns the summary of every job that ran at least once
+func (r *Runner) summarizeHistory() (map[string]*jobSummary, error) {
+	runs, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[string]*jobSummary)
+
+	// runs are sorted newest first
+	for i := range runs {
+		for j := range runs[i].Jobs {
+			res := &runs[i].Jobs[j]
+			if res.Status == StatusSkipped {
+				continue
+			}
+
+			sum, ok := summaries[res.ID]
+			if !ok {
+				sum = &jobSummary{last: res}
+				summaries[res.ID] = sum
+			}
+
+			switch res.Status {
+			case StatusSuccess, StatusWarning:
+				if sum.lastSuccess == nil {
+					sum.lastSuccess = res
+				}
+			case StatusFailed, StatusTimedOut:
+				if sum.lastSuccess == nil {
+					sum.failures++
+				}
+			}
+
+			if sum.lastStats == nil && (res.Restic != nil || res.Rclone != nil) {
+				sum.lastStats = res
+			}
+
+			if sum.lastCheck == nil && res.Differences != nil {
+				sum.lastCheck = res
+			}
+		}
+	}
+
+	return summaries, nil
+}
+
+// history returns all recorded runs, newest first. There are no runs if the history database
+// does not exist yet.
+func (r *Runner) history() ([]history.Run, error) {
+	path := history.Path(r.stateDir)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	store, err := history.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer store.Close()
+
+	return store.List(history.Filter{})
+}
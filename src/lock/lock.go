@@ -0,0 +1,197 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package lock ensures that only one backup-and-sync run is executed at the same time
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/statefile"
+)
+
+// pollInterval is the time between two attempts to acquire a lock held by another process
+const pollInterval = time.Second
+
+// errLocked is returned by open if another process holds the lock
+var errLocked = errors.New("locked")
+
+// Info describes the process holding a lock
+type Info struct {
+	PID   int       `json:"pid"`
+	Start time.Time `json:"start"`
+}
+
+// LockedError is returned if the lock is held by another process
+type LockedError struct {
+	Path string
+	// Info is nil if the lock file could not be read
+	Info *Info
+}
+
+func (e *LockedError) Error() string {
+	if e.Info == nil {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+
+	return fmt.Sprintf("%s is locked by process %d running since %s", e.Path, e.Info.PID, e.Info.Start.Format(time.RFC3339))
+}
+
+// IsLocked reports whether err is caused by a lock held by another process
+func IsLocked(err error) bool {
+	_, ok := errors.Cause(err).(*LockedError)
+	return ok
+}
+
+// DefaultPath returns the lock file used if no lock file is configured
+func DefaultPath() string {
+	return filepath.Join(statefile.DefaultDir(), "backup.lock")
+}
+
+// Lock is an acquired lock file. The operating system releases the lock if the process exits,
+// so a crashed process never blocks later runs.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire locks the file at path and writes the PID and start time of the current process to
+// it. It does not wait if another process holds the lock. A lock file that still contains the
+// information of a previous process is left over by a process that crashed. It is taken over.
+func Acquire(path string) (*Lock, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create lock directory failed")
+	}
+
+	file, err := open(path)
+	if err == errLocked {
+		return nil, &LockedError{Path: path, Info: readInfo(path)}
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "open lock file failed")
+	}
+
+	var stale Info
+	if json.NewDecoder(file).Decode(&stale) == nil {
+		log.WithFields(log.Fields{
+			"path":  path,
+			"pid":   stale.PID,
+			"start": stale.Start,
+		}).Warn("Removing stale lock of a process that did not exit cleanly")
+	}
+
+	info := Info{PID: os.Getpid(), Start: time.Now()}
+
+	err = write(file, &info)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "write lock file failed")
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// Wait acquires the lock like Acquire. If another process holds the lock it waits until the
+// lock is released, the timeout is reached or ctx is cancelled. A timeout of zero waits forever.
+func Wait(ctx context.Context, path string, timeout time.Duration) (*Lock, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	logged := false
+
+	for {
+		l, err := Acquire(path)
+		if !IsLocked(err) {
+			return l, err
+		}
+
+		if !logged {
+			log.WithError(err).Info("Waiting for the running backup to finish")
+			logged = true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, errors.Wrap(err, "waiting for lock failed")
+		}
+	}
+}
+
+// Release clears the lock file and releases the lock. The file is kept, removing it would
+// allow two processes to lock different files with the same path.
+func (l *Lock) Release() error {
+	err := l.file.Truncate(0)
+	if err != nil {
+		l.file.Close()
+		return errors.Wrap(err, "clear lock file failed")
+	}
+
+	return errors.Wrap(l.file.Close(), "release lock failed")
+}
+
+func write(file *os.File, info *Info) error {
+	err := file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteAt(data, 0)
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// readInfo returns the information stored in the lock file or nil if it can not be read
+func readInfo(path string) *Info {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var info Info
+	if json.Unmarshal(data, &info) != nil {
+		return nil
+	}
+
+	return &info
+}
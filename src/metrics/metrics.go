@@ -0,0 +1,281 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package metrics exports the results of the jobs in the Prometheus text format
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/rclone"
+	"github.com/th3noname/backup-and-sync/src/restic"
+)
+
+// FileName is the name of the metrics file in the textfile directory
+const FileName = "backup-and-sync.prom"
+
+// prefix of every metric name
+const prefix = "backup_and_sync_"
+
+// shutdownTimeout is the time the HTTP server gets to finish running requests
+const shutdownTimeout = 5 * time.Second
+
+// Config contains the metrics settings
+type Config struct {
+	// TextfileDir is the directory of the node_exporter textfile collector
+	TextfileDir string `mapstructure:"textfile-dir"`
+	// Listen is the address the daemon serves /metrics on, e.g. :9616
+	Listen string `mapstructure:"listen"`
+}
+
+// Job contains the metrics of a single job
+type Job struct {
+	ID   string
+	Type string
+	// Status, Start and End describe the last run of the job. Status is empty if the job never ran.
+	Status string
+	Start  time.Time
+	End    time.Time
+	// LastSuccess is the start of the last run that succeeded or finished with a warning
+	LastSuccess time.Time
+	// Restic and Rclone contain the statistics of the last run with statistics
+	Restic *restic.Summary
+	Rclone *rclone.Stats
+	// Snapshots is the number of snapshots of a restic backup. Nil if unknown.
+	Snapshots *int
+}
+
+// statuses lists the values of the status metric
+var statuses = []string{"success", "warning", "failed", "timed-out", "cancelled"}
+
+type sample struct {
+	labels []string // name value pairs
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	samples []sample
+}
+
+func (f *family) add(value float64, labels ...string) {
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+// Write writes the metrics of the jobs in the Prometheus text format
+func Write(w io.Writer, jobs []Job) error {
+	var (
+		lastRun     = &family{name: "job_last_run_timestamp_seconds", help: "Start of the last run of the job."}
+		duration    = &family{name: "job_duration_seconds", help: "Duration of the last run of the job."}
+		status      = &family{name: "job_status", help: "Status of the last run of the job. 1 for the current status."}
+		lastSuccess = &family{name: "job_last_success_timestamp_seconds", help: "Start of the last successful run of the job."}
+		added       = &family{name: "restic_data_added_bytes", help: "Bytes added to the repository by the last backup."}
+		processed   = &family{name: "restic_files_processed", help: "Files processed by the last backup."}
+		transferred = &family{name: "rclone_transferred_bytes", help: "Bytes transferred by the last run of the rclone job."}
+		transfers   = &family{name: "rclone_transferred_files", help: "Files transferred by the last run of the rclone job."}
+		snapshots   = &family{name: "restic_snapshots", help: "Number of snapshots of the backup in its repository."}
+	)
+
+	for _, j := range jobs {
+		labels := []string{"job", j.ID, "type", j.Type}
+
+		if j.Status != "" {
+			lastRun.add(seconds(j.Start), labels...)
+			duration.add(j.End.Sub(j.Start).Seconds(), labels...)
+
+			for _, s := range statuses {
+				value := 0.0
+				if s == j.Status {
+					value = 1
+				}
+
+				status.add(value, append(labels, "status", s)...)
+			}
+		}
+
+		if !j.LastSuccess.IsZero() {
+			lastSuccess.add(seconds(j.LastSuccess), labels...)
+		}
+
+		if j.Restic != nil {
+			added.add(float64(j.Restic.DataAdded), labels...)
+			processed.add(float64(j.Restic.TotalFilesProcessed), labels...)
+		}
+
+		if j.Rclone != nil {
+			transferred.add(float64(j.Rclone.Bytes), labels...)
+			transfers.add(float64(j.Rclone.Transfers), labels...)
+		}
+
+		if j.Snapshots != nil {
+			snapshots.add(float64(*j.Snapshots), labels...)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	for _, f := range []*family{lastRun, duration, status, lastSuccess, added, processed, transferred, transfers, snapshots} {
+		if len(f.samples) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "# HELP %s%s %s\n", prefix, f.name, f.help)
+		fmt.Fprintf(&buf, "# TYPE %s%s gauge\n", prefix, f.name)
+
+		for _, s := range f.samples {
+			fmt.Fprintf(&buf, "%s%s{%s} %s\n", prefix, f.name, formatLabels(s.labels),
+				strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteFile writes the metrics atomically to the textfile directory
+func WriteFile(dir string, jobs []Job) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "create textfile directory failed")
+	}
+
+	var buf bytes.Buffer
+
+	err = Write(&buf, jobs)
+	if err != nil {
+		return errors.Wrap(err, "encode metrics failed")
+	}
+
+	path := filepath.Join(dir, FileName)
+	// the textfile collector only reads files ending with .prom
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write metrics file failed")
+	}
+
+	return errors.Wrap(os.Rename(tmp, path), "write metrics file failed")
+}
+
+// Handler serves the latest metrics
+type Handler struct {
+	mutex *sync.Mutex
+	data  []byte
+}
+
+// NewHandler creates a Handler without metrics
+func NewHandler() *Handler {
+	return &Handler{mutex: &sync.Mutex{}}
+}
+
+// Update replaces the served metrics
+func (h *Handler) Update(jobs []Job) error {
+	var buf bytes.Buffer
+
+	err := Write(&buf, jobs)
+	if err != nil {
+		return errors.Wrap(err, "encode metrics failed")
+	}
+
+	h.mutex.Lock()
+	h.data = buf.Bytes()
+	h.mutex.Unlock()
+
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mutex.Lock()
+	data := h.data
+	h.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(data)
+}
+
+// Serve serves the handler on /metrics in the background until ctx is cancelled. An error is
+// returned if listening on addr fails.
+func Serve(ctx context.Context, addr string, h *Handler) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listen for metrics requests failed")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("serving metrics failed")
+		}
+	}()
+
+	log.WithField("address", listener.Addr()).Info("serving metrics on /metrics")
+
+	return nil
+}
+
+func seconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// formatLabels formats name value pairs, e.g. job="backup-a",type="restic-backup"
+func formatLabels(labels []string) string {
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", labels[i], escape(labels[i+1])))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return escaper.Replace(value)
+}
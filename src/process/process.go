@@ -0,0 +1,97 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+// Package process runs external commands that can be cancelled gracefully
+package process
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GracePeriod is the time a process has to exit after it was asked to terminate
+// before it is killed
+var GracePeriod = 30 * time.Second
+
+// Start starts the command in its own process group. Signals sent to backup-and-sync (e.g. by
+// pressing Ctrl+C) are not delivered to the process directly, it is terminated by Watch.
+func Start(cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	return cmd.Start()
+}
+
+// Watch terminates the process group of the started command if ctx is cancelled. If the process
+// does not exit within the grace period it is killed. The returned function must be called
+// after the command exited.
+func Watch(ctx context.Context, cmd *exec.Cmd) (stop func()) {
+	exited := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+
+		log.WithField("pid", cmd.Process.Pid).Warnf("terminating %s", cmd.Path)
+		terminate(cmd)
+
+		select {
+		case <-exited:
+		case <-time.After(GracePeriod):
+			log.WithField("pid", cmd.Process.Pid).Warnf("%s did not exit within %s. Killing process", cmd.Path, GracePeriod)
+			kill(cmd)
+		}
+	}()
+
+	return func() {
+		close(exited)
+		<-done
+	}
+}
+
+// Run starts the command and waits until it exits. The command is terminated if ctx is cancelled.
+func Run(ctx context.Context, cmd *exec.Cmd) error {
+	err := Start(cmd)
+	if err != nil {
+		return err
+	}
+
+	stop := Watch(ctx, cmd)
+	err = cmd.Wait()
+	stop()
+
+	return err
+}
+
+// Output runs the command like Run and returns its standard output
+func Output(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	err := Run(ctx, cmd)
+	return stdout.Bytes(), err
+}
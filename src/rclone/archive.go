@@ -0,0 +1,177 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/retry"
+)
+
+// archiveDateLayout is the name format of the dated archive directories
+const archiveDateLayout = "2006-01-02_15-04-05"
+
+// KeepDeleted configures a sync job to move deleted and overwritten files into dated
+// directories below Archive instead of removing them
+type KeepDeleted struct {
+	Archive   string `mapstructure:"archive"`
+	Suffix    string `mapstructure:"suffix"`
+	Retention string `mapstructure:"retention"`
+}
+
+func (k *KeepDeleted) enabled() bool {
+	return k.Archive != ""
+}
+
+func (k *KeepDeleted) validate() error {
+	if !k.enabled() {
+		if k.Suffix != "" || k.Retention != "" {
+			return errors.New("keep-deleted requires an archive")
+		}
+
+		return nil
+	}
+
+	if k.Retention != "" {
+		if _, err := parseDuration(k.Retention); err != nil {
+			return errors.Wrap(err, "invalid keep-deleted retention")
+		}
+	}
+
+	return nil
+}
+
+// args returns the rclone arguments to move deleted files into the archive directory for runDate
+func (k *KeepDeleted) args(runDate time.Time) []string {
+	args := []string{"--backup-dir", joinRemote(k.Archive, runDate.Format(archiveDateLayout))}
+
+	if k.Suffix != "" {
+		args = append(args, "--suffix", k.Suffix)
+	}
+
+	return args
+}
+
+// rcConfig returns the _config parameters to move deleted files into the archive directory for runDate
+func (k *KeepDeleted) rcConfig(runDate time.Time) map[string]interface{} {
+	config := map[string]interface{}{
+		"BackupDir": joinRemote(k.Archive, runDate.Format(archiveDateLayout)),
+	}
+
+	if k.Suffix != "" {
+		config["Suffix"] = k.Suffix
+	}
+
+	return config
+}
+
+// cleanup removes all dated archive directories that are older than the retention
+func (k *KeepDeleted) cleanup(ctx context.Context, env *environment, now time.Time, fields log.Fields) error {
+	if k.Retention == "" {
+		return nil
+	}
+
+	retention, err := parseDuration(k.Retention)
+	if err != nil {
+		return err
+	}
+
+	out, err := env.executeOutput(ctx, []string{"lsf", "--dirs-only", k.Archive}, fields)
+	if code, ok := retry.ExitCode(err); ok && code == exitDirNotFound {
+		// the archive is created by the first sync that moves files into it
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "list archive directories failed")
+	}
+
+	for _, dir := range strings.Split(string(out), "\n") {
+		dir = strings.TrimSuffix(strings.TrimSpace(dir), "/")
+		if dir == "" {
+			continue
+		}
+
+		date, err := time.ParseInLocation(archiveDateLayout, dir, now.Location())
+		if err != nil {
+			// not created by backup-and-sync
+			continue
+		}
+
+		if now.Sub(date) <= retention {
+			continue
+		}
+
+		path := joinRemote(k.Archive, dir)
+
+		log.WithFields(fields).WithField("archive", path).Info("remove expired archive directory")
+
+		err = env.execute(ctx, []string{"purge", path}, &Result{Fields: fields})
+		if err != nil {
+			return errors.Wrapf(err, "remove archive directory \"%s\" failed", path)
+		}
+	}
+
+	return nil
+}
+
+// joinRemote appends elem to an rclone path (e.g. "remote:" or "remote:path")
+func joinRemote(path string, elem string) string {
+	if strings.HasSuffix(path, ":") || strings.HasSuffix(path, "/") {
+		return path + elem
+	}
+
+	return path + "/" + elem
+}
+
+// parseDuration parses a duration in rclone's format. In addition to the units supported by
+// time.ParseDuration the suffixes d (days), w (weeks), M (months) and y (years) are supported.
+func parseDuration(value string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"M": 30 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+
+	for suffix, unit := range units {
+		if strings.HasSuffix(value, suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(value, suffix), 64)
+			if err != nil {
+				return 0, errors.Errorf("invalid duration \"%s\"", value)
+			}
+
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Errorf("invalid duration \"%s\"", value)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,75 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package rclone
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		valid bool
+	}{
+		{"90s", 90 * time.Second, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"5m", 5 * time.Minute, true},
+		{"2d", 48 * time.Hour, true},
+		{"1.5d", 36 * time.Hour, true},
+		{"1w", 7 * 24 * time.Hour, true},
+		{"1M", 30 * 24 * time.Hour, true},
+		{"1y", 365 * 24 * time.Hour, true},
+		{"", 0, false},
+		{"d", 0, false},
+		{"90", 0, false},
+		{"ten days", 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseDuration(test.value)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("parseDuration(%q) error = %v, want valid %t", test.value, err, test.valid)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("parseDuration(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestJoinRemote(t *testing.T) {
+	tests := []struct {
+		path, elem, want string
+	}{
+		{"remote:", "2019-05-01", "remote:2019-05-01"},
+		{"remote:archive", "2019-05-01", "remote:archive/2019-05-01"},
+		{"remote:archive/", "2019-05-01", "remote:archive/2019-05-01"},
+		{"/local/archive", "2019-05-01", "/local/archive/2019-05-01"},
+	}
+
+	for _, test := range tests {
+		if got := joinRemote(test.path, test.elem); got != test.want {
+			t.Errorf("joinRemote(%q, %q) = %q, want %q", test.path, test.elem, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/retry"
+)
+
+// Bisync represents a single rclone bisync job
+type Bisync struct {
+	Path1           string        `mapstructure:"path1"`
+	Path2           string        `mapstructure:"path2"`
+	Workdir         string        `mapstructure:"workdir"`
+	ConflictResolve string        `mapstructure:"conflict-resolve"`
+	ConflictLoser   string        `mapstructure:"conflict-loser"`
+	ConflictSuffix  string        `mapstructure:"conflict-suffix"`
+	CheckAccess     bool          `mapstructure:"check-access"`
+	CheckFilename   string        `mapstructure:"check-filename"`
+	MaxDelete       int           `mapstructure:"max-delete"`
+	Options         Options       `mapstructure:",squash"`
+	Filters         Filters       `mapstructure:",squash"`
+	ContinueOnError bool          `mapstructure:"continue-on-error"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	Retry           retry.Policy  `mapstructure:",squash"`
+}
+
+func (b *Bisync) name() string {
+	return "bisync"
+}
+
+func (b *Bisync) continueOnError() bool {
+	return b.ContinueOnError
+}
+
+func (b *Bisync) timeout() time.Duration {
+	return b.Timeout
+}
+
+func (b *Bisync) retryPolicy() retry.Policy {
+	return b.Retry
+}
+
+func (b *Bisync) validate() error {
+	if b.Path1 == "" || b.Path2 == "" {
+		return errors.New("path1 and path2 are required")
+	}
+
+	switch b.ConflictResolve {
+	case "", "none", "path1", "path2", "newer", "older", "larger", "smaller":
+	default:
+		return errors.Errorf("invalid conflict-resolve \"%s\"", b.ConflictResolve)
+	}
+
+	switch b.ConflictLoser {
+	case "", "num", "pathname", "delete":
+	default:
+		return errors.Errorf("invalid conflict-loser \"%s\"", b.ConflictLoser)
+	}
+
+	if b.CheckFilename != "" && !b.CheckAccess {
+		return errors.New("check-filename requires check-access")
+	}
+
+	if b.MaxDelete < 0 || b.MaxDelete > 100 {
+		return errors.New("max-delete must be between 0 and 100")
+	}
+
+	if err := b.Options.validate(); err != nil {
+		return err
+	}
+
+	return b.Filters.validate()
+}
+
+func (b *Bisync) paths() []string {
+	return []string{b.Path1, b.Path2}
+}
+
+func (b *Bisync) logFields() log.Fields {
+	return log.Fields{
+		"path1": b.Path1,
+		"path2": b.Path2,
+	}
+}
+
+// stateKey identifies the job in the state file
+func (b *Bisync) stateKey() string {
+	return b.Path1 + "|" + b.Path2
+}
+
+func (b *Bisync) run(ctx context.Context, env *environment, res *Result) error {
+	log.WithFields(b.logFields()).Infof("start run rclone %s", b.name())
+
+	args := []string{b.name()}
+	args = append(args, b.Path1)
+	args = append(args, b.Path2)
+	args = append(args, b.Options.args()...)
+
+	if b.Workdir != "" {
+		args = append(args, "--workdir", b.Workdir)
+	}
+
+	if b.ConflictResolve != "" {
+		args = append(args, "--conflict-resolve", b.ConflictResolve)
+	}
+
+	if b.ConflictLoser != "" {
+		args = append(args, "--conflict-loser", b.ConflictLoser)
+	}
+
+	if b.ConflictSuffix != "" {
+		args = append(args, "--conflict-suffix", b.ConflictSuffix)
+	}
+
+	if b.CheckAccess {
+		args = append(args, "--check-access")
+	}
+
+	if b.CheckFilename != "" {
+		args = append(args, "--check-filename", b.CheckFilename)
+	}
+
+	if b.MaxDelete > 0 {
+		args = append(args, "--max-delete", strconv.Itoa(b.MaxDelete))
+	}
+
+	args = append(args, b.Filters.args()...)
+
+	initialized := env.state.bisyncInitialized(b.stateKey())
+	if !initialized {
+		log.WithFields(b.logFields()).Info("first run of bisync job. Running with --resync")
+		args = append(args, "--resync")
+	}
+
+	err := env.execute(ctx, args, res)
+	if err != nil {
+		log.WithFields(b.logFields()).Infof("end run rclone %s", b.name())
+		return errors.Wrap(err, "execute failed")
+	}
+
+	if !initialized {
+		err = env.state.setBisyncInitialized(b.stateKey(), time.Now())
+		if err != nil {
+			log.WithFields(b.logFields()).Infof("end run rclone %s", b.name())
+			return errors.Wrap(err, "save bisync state failed")
+		}
+	}
+
+	log.WithFields(b.logFields()).Infof("end run rclone %s", b.name())
+	return nil
+}
@@ -0,0 +1,297 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/retry"
+)
+
+// Differences contains the files reported by rclone check and cryptcheck
+type Differences struct {
+	MissingOnDst []string
+	MissingOnSrc []string
+	Differ       []string
+	Errors       []string
+}
+
+// Count returns the total number of differences
+func (d *Differences) Count() int {
+	return len(d.MissingOnDst) + len(d.MissingOnSrc) + len(d.Differ) + len(d.Errors)
+}
+
+// DifferenceCount contains the number of differences of every kind
+type DifferenceCount struct {
+	MissingOnDst int `json:"missing-on-dst"`
+	MissingOnSrc int `json:"missing-on-src"`
+	Differ       int `json:"differ"`
+	Errors       int `json:"errors"`
+}
+
+// Total returns the total number of differences
+func (c *DifferenceCount) Total() int {
+	return c.MissingOnDst + c.MissingOnSrc + c.Differ + c.Errors
+}
+
+// Counts returns the number of differences of every kind
+func (d *Differences) Counts() *DifferenceCount {
+	return &DifferenceCount{
+		MissingOnDst: len(d.MissingOnDst),
+		MissingOnSrc: len(d.MissingOnSrc),
+		Differ:       len(d.Differ),
+		Errors:       len(d.Errors),
+	}
+}
+
+// parseCombined reads a file written by rclone's --combined option
+func parseCombined(path string) (*Differences, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open combined output failed")
+	}
+	defer f.Close()
+
+	d := &Differences{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 3 {
+			continue
+		}
+
+		file := line[2:]
+
+		switch line[0] {
+		case '-':
+			d.MissingOnDst = append(d.MissingOnDst, file)
+		case '+':
+			d.MissingOnSrc = append(d.MissingOnSrc, file)
+		case '*':
+			d.Differ = append(d.Differ, file)
+		case '!':
+			d.Errors = append(d.Errors, file)
+		}
+	}
+
+	return d, errors.Wrap(scanner.Err(), "read combined output failed")
+}
+
+// runCheck executes a check command and stores the differences in the result.
+// The check fails if rclone reports more than maxDifferences differences.
+func runCheck(ctx context.Context, env *environment, args []string, maxDifferences int, res *Result) error {
+	f, err := ioutil.TempFile("", "backup-and-sync-check-")
+	if err != nil {
+		return errors.Wrap(err, "create combined output file failed")
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	args = append(args, "--combined", f.Name())
+
+	// rclone exits with the uncategorized error code 1 if differences are found, so the report
+	// is parsed before max-differences is applied. Other errors and cancelled or timed out
+	// checks fail the job regardless of the differences.
+	execErr := env.execute(ctx, args, res)
+	if execErr != nil {
+		if code, ok := retry.ExitCode(execErr); ctx.Err() != nil || !ok || code != exitUncategorized {
+			return execErr
+		}
+	}
+
+	d, err := parseCombined(f.Name())
+	if err != nil {
+		return err
+	}
+
+	res.Differences = d
+
+	log.WithFields(res.Fields).WithFields(log.Fields{
+		"missing-on-dst": len(d.MissingOnDst),
+		"missing-on-src": len(d.MissingOnSrc),
+		"differ":         len(d.Differ),
+		"errors":         len(d.Errors),
+	}).Info("rclone check finished")
+
+	if d.Count() > maxDifferences {
+		return errors.Errorf("found %d differences (missing on destination: %d, missing on source: %d, differ: %d, errors: %d). At most %d are allowed",
+			d.Count(), len(d.MissingOnDst), len(d.MissingOnSrc), len(d.Differ), len(d.Errors), maxDifferences)
+	}
+
+	if execErr != nil && d.Count() == 0 {
+		return execErr
+	}
+
+	return nil
+}
+
+// Check represents a single rclone check job
+type Check struct {
+	Source          string        `mapstructure:"source"`
+	Destination     string        `mapstructure:"destination"`
+	OneWay          bool          `mapstructure:"one-way"`
+	Download        bool          `mapstructure:"download"`
+	CheckFile       string        `mapstructure:"checkfile"`
+	MaxDifferences  int           `mapstructure:"max-differences"`
+	Options         Options       `mapstructure:",squash"`
+	Filters         Filters       `mapstructure:",squash"`
+	ContinueOnError bool          `mapstructure:"continue-on-error"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	Retry           retry.Policy  `mapstructure:",squash"`
+}
+
+func (c *Check) name() string {
+	return "check"
+}
+
+func (c *Check) continueOnError() bool {
+	return c.ContinueOnError
+}
+
+func (c *Check) timeout() time.Duration {
+	return c.Timeout
+}
+
+func (c *Check) retryPolicy() retry.Policy {
+	return c.Retry
+}
+
+func (c *Check) validate() error {
+	if err := c.Options.validate(); err != nil {
+		return err
+	}
+
+	return c.Filters.validate()
+}
+
+func (c *Check) paths() []string {
+	return []string{c.Source, c.Destination}
+}
+
+func (c *Check) logFields() log.Fields {
+	return log.Fields{
+		"source":      c.Source,
+		"destination": c.Destination,
+	}
+}
+
+func (c *Check) run(ctx context.Context, env *environment, res *Result) error {
+	log.WithFields(c.logFields()).Infof("start run rclone %s", c.name())
+
+	args := []string{c.name()}
+	args = append(args, c.Source)
+	args = append(args, c.Destination)
+
+	if c.OneWay {
+		args = append(args, "--one-way")
+	}
+
+	if c.Download {
+		args = append(args, "--download")
+	}
+
+	if c.CheckFile != "" {
+		args = append(args, "--checkfile", c.CheckFile)
+	}
+
+	args = append(args, c.Options.args()...)
+	args = append(args, c.Filters.args()...)
+
+	err := runCheck(ctx, env, args, c.MaxDifferences, res)
+
+	log.WithFields(c.logFields()).Infof("end run rclone %s", c.name())
+	return errors.Wrap(err, "check failed")
+}
+
+// CryptCheck represents a single rclone cryptcheck job
+type CryptCheck struct {
+	Source          string        `mapstructure:"source"`
+	Destination     string        `mapstructure:"destination"`
+	OneWay          bool          `mapstructure:"one-way"`
+	MaxDifferences  int           `mapstructure:"max-differences"`
+	Options         Options       `mapstructure:",squash"`
+	Filters         Filters       `mapstructure:",squash"`
+	ContinueOnError bool          `mapstructure:"continue-on-error"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	Retry           retry.Policy  `mapstructure:",squash"`
+}
+
+func (c *CryptCheck) name() string {
+	return "cryptcheck"
+}
+
+func (c *CryptCheck) continueOnError() bool {
+	return c.ContinueOnError
+}
+
+func (c *CryptCheck) timeout() time.Duration {
+	return c.Timeout
+}
+
+func (c *CryptCheck) retryPolicy() retry.Policy {
+	return c.Retry
+}
+
+func (c *CryptCheck) validate() error {
+	if err := c.Options.validate(); err != nil {
+		return err
+	}
+
+	return c.Filters.validate()
+}
+
+func (c *CryptCheck) paths() []string {
+	return []string{c.Source, c.Destination}
+}
+
+func (c *CryptCheck) logFields() log.Fields {
+	return log.Fields{
+		"source":      c.Source,
+		"destination": c.Destination,
+	}
+}
+
+func (c *CryptCheck) run(ctx context.Context, env *environment, res *Result) error {
+	log.WithFields(c.logFields()).Infof("start run rclone %s", c.name())
+
+	args := []string{c.name()}
+	args = append(args, c.Source)
+	args = append(args, c.Destination)
+
+	if c.OneWay {
+		args = append(args, "--one-way")
+	}
+
+	args = append(args, c.Options.args()...)
+	args = append(args, c.Filters.args()...)
+
+	err := runCheck(ctx, env, args, c.MaxDifferences, res)
+
+	log.WithFields(c.logFields()).Infof("end run rclone %s", c.name())
+	return errors.Wrap(err, "cryptcheck failed")
+}
@@ -0,0 +1,70 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package rclone
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseCombined(t *testing.T) {
+	f, err := ioutil.TempFile("", "backup-and-sync-combined-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString("= same.txt\n- missing on dst.txt\n+ dir/missing on src.txt\n* differ.txt\n! error.txt\n- second.txt\n\n?\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := parseCombined(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Differences{
+		MissingOnDst: []string{"missing on dst.txt", "second.txt"},
+		MissingOnSrc: []string{"dir/missing on src.txt"},
+		Differ:       []string{"differ.txt"},
+		Errors:       []string{"error.txt"},
+	}
+
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("parseCombined = %+v, want %+v", d, want)
+	}
+
+	if d.Count() != 5 {
+		t.Errorf("Count = %d, want 5", d.Count())
+	}
+
+	counts := DifferenceCount{MissingOnDst: 2, MissingOnSrc: 1, Differ: 1, Errors: 1}
+	if !reflect.DeepEqual(*d.Counts(), counts) || counts.Total() != 5 {
+		t.Errorf("Counts = %+v, want %+v", *d.Counts(), counts)
+	}
+
+	if _, err := parseCombined(f.Name() + ".missing"); err == nil {
+		t.Error("parseCombined of a missing file succeeded")
+	}
+}
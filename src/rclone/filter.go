@@ -0,0 +1,253 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	sizePattern     = regexp.MustCompile(`^(off|\d+(\.\d+)?[bBkKmMgGtTpP]?)$`)
+	durationPattern = regexp.MustCompile(`^(off|(\d+(\.\d+)?(ms|s|m|h|d|w|M|y)?)+)$`)
+	ageDateLayouts  = []string{"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339}
+)
+
+// Filters contains the filter rules that can be set on every rclone job
+type Filters struct {
+	Include    []string `mapstructure:"include"`
+	Exclude    []string `mapstructure:"exclude"`
+	Filter     []string `mapstructure:"filter"`
+	FilterFrom []string `mapstructure:"filter-from"`
+	MinSize    string   `mapstructure:"min-size"`
+	MaxSize    string   `mapstructure:"max-size"`
+	MinAge     string   `mapstructure:"min-age"`
+	MaxAge     string   `mapstructure:"max-age"`
+}
+
+// args returns the rclone arguments for the filter rules
+func (f *Filters) args() []string {
+	var args []string
+
+	for _, v := range f.Include {
+		args = append(args, "--include", v)
+	}
+
+	for _, v := range f.Exclude {
+		args = append(args, "--exclude", v)
+	}
+
+	for _, v := range f.Filter {
+		args = append(args, "--filter", v)
+	}
+
+	for _, v := range f.FilterFrom {
+		args = append(args, "--filter-from", v)
+	}
+
+	if f.MinSize != "" {
+		args = append(args, "--min-size", f.MinSize)
+	}
+
+	if f.MaxSize != "" {
+		args = append(args, "--max-size", f.MaxSize)
+	}
+
+	if f.MinAge != "" {
+		args = append(args, "--min-age", f.MinAge)
+	}
+
+	if f.MaxAge != "" {
+		args = append(args, "--max-age", f.MaxAge)
+	}
+
+	return args
+}
+
+// rcFilter returns the filter rules as _filter parameter for the remote control API
+func (f *Filters) rcFilter() map[string]interface{} {
+	filter := map[string]interface{}{}
+
+	if len(f.Include) > 0 {
+		filter["IncludeRule"] = f.Include
+	}
+
+	if len(f.Exclude) > 0 {
+		filter["ExcludeRule"] = f.Exclude
+	}
+
+	if len(f.Filter) > 0 {
+		filter["FilterRule"] = f.Filter
+	}
+
+	if len(f.FilterFrom) > 0 {
+		filter["FilterFrom"] = f.FilterFrom
+	}
+
+	if f.MinSize != "" {
+		filter["MinSize"] = f.MinSize
+	}
+
+	if f.MaxSize != "" {
+		filter["MaxSize"] = f.MaxSize
+	}
+
+	if f.MinAge != "" {
+		filter["MinAge"] = f.MinAge
+	}
+
+	if f.MaxAge != "" {
+		filter["MaxAge"] = f.MaxAge
+	}
+
+	return filter
+}
+
+// validate checks the filter rules before rclone is started. Combining include with exclude or
+// filter is rejected by backup-and-sync itself: rclone only warns about it, but the order in
+// which rclone applies the mixed rules rarely matches what was intended.
+func (f *Filters) validate() error {
+	if len(f.Include) > 0 && (len(f.Exclude) > 0 || len(f.Filter) > 0) {
+		return errors.New("include can not be combined with exclude or filter. Use filter rules instead")
+	}
+
+	for _, v := range f.Include {
+		if err := validatePattern(v); err != nil {
+			return errors.Wrap(err, "invalid include")
+		}
+	}
+
+	for _, v := range f.Exclude {
+		if err := validatePattern(v); err != nil {
+			return errors.Wrap(err, "invalid exclude")
+		}
+	}
+
+	for _, v := range f.Filter {
+		if err := validateFilterRule(v); err != nil {
+			return errors.Wrap(err, "invalid filter")
+		}
+	}
+
+	for _, v := range f.FilterFrom {
+		if _, err := os.Stat(v); err != nil {
+			return errors.Wrapf(err, "invalid filter-from \"%s\"", v)
+		}
+	}
+
+	if f.MinSize != "" && !sizePattern.MatchString(f.MinSize) {
+		return errors.Errorf("invalid min-size \"%s\"", f.MinSize)
+	}
+
+	if f.MaxSize != "" && !sizePattern.MatchString(f.MaxSize) {
+		return errors.Errorf("invalid max-size \"%s\"", f.MaxSize)
+	}
+
+	if f.MinAge != "" && !isAge(f.MinAge) {
+		return errors.Errorf("invalid min-age \"%s\"", f.MinAge)
+	}
+
+	if f.MaxAge != "" && !isAge(f.MaxAge) {
+		return errors.Errorf("invalid max-age \"%s\"", f.MaxAge)
+	}
+
+	return nil
+}
+
+// validateFilterRule checks a single rule in rclone's filter syntax (e.g. "- *.tmp")
+func validateFilterRule(rule string) error {
+	if rule == "!" {
+		return nil
+	}
+
+	if !strings.HasPrefix(rule, "+ ") && !strings.HasPrefix(rule, "- ") {
+		return errors.Errorf("rule \"%s\" must start with \"+ \" or \"- \"", rule)
+	}
+
+	return validatePattern(rule[2:])
+}
+
+// validatePattern checks that a glob pattern is not empty and its brackets and braces are balanced
+func validatePattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return errors.New("pattern is empty")
+	}
+
+	braces := 0
+	inBrackets := false
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			if inBrackets {
+				return errors.Errorf("pattern \"%s\" contains nested brackets", pattern)
+			}
+			inBrackets = true
+		case ']':
+			if !inBrackets {
+				return errors.Errorf("pattern \"%s\" contains an unopened bracket", pattern)
+			}
+			inBrackets = false
+		case '{':
+			if !inBrackets {
+				braces++
+			}
+		case '}':
+			if !inBrackets {
+				braces--
+				if braces < 0 {
+					return errors.Errorf("pattern \"%s\" contains an unopened brace", pattern)
+				}
+			}
+		}
+	}
+
+	if inBrackets {
+		return errors.Errorf("pattern \"%s\" contains an unclosed bracket", pattern)
+	}
+
+	if braces != 0 {
+		return errors.Errorf("pattern \"%s\" contains an unclosed brace", pattern)
+	}
+
+	return nil
+}
+
+// isAge reports whether value is a valid rclone age (duration or date)
+func isAge(value string) bool {
+	if durationPattern.MatchString(value) {
+		return true
+	}
+
+	for _, layout := range ageDateLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
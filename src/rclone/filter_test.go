@@ -0,0 +1,101 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package rclone
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFiltersValidate(t *testing.T) {
+	filterFile, err := ioutil.TempFile("", "backup-and-sync-filter-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filterFile.Close()
+	defer os.Remove(filterFile.Name())
+
+	tests := []struct {
+		name    string
+		filters Filters
+		err     string
+	}{
+		{"empty", Filters{}, ""},
+		{"include", Filters{Include: []string{"*.jpg", "/photos/**"}}, ""},
+		{"exclude and filter", Filters{Exclude: []string{".DS_Store"}, Filter: []string{"- *.tmp", "+ **", "!"}}, ""},
+		{"include and exclude", Filters{Include: []string{"*.jpg"}, Exclude: []string{"*.tmp"}}, "can not be combined"},
+		{"include and filter", Filters{Include: []string{"*.jpg"}, Filter: []string{"- *.tmp"}}, "can not be combined"},
+		{"empty include", Filters{Include: []string{" "}}, "invalid include"},
+		{"unclosed bracket", Filters{Exclude: []string{"[abc"}}, "invalid exclude"},
+		{"filter without sign", Filters{Filter: []string{"*.tmp"}}, "invalid filter"},
+		{"filter without space", Filters{Filter: []string{"-*.tmp"}}, "invalid filter"},
+		{"filter-from", Filters{FilterFrom: []string{filterFile.Name()}}, ""},
+		{"missing filter-from", Filters{FilterFrom: []string{filterFile.Name() + ".missing"}}, "invalid filter-from"},
+		{"sizes", Filters{MinSize: "1K", MaxSize: "10G"}, ""},
+		{"invalid min-size", Filters{MinSize: "1KB"}, "invalid min-size"},
+		{"invalid max-size", Filters{MaxSize: "-1"}, "invalid max-size"},
+		{"ages", Filters{MinAge: "90d", MaxAge: "2019-05-01"}, ""},
+		{"combined age", Filters{MinAge: "1h30m"}, ""},
+		{"invalid min-age", Filters{MinAge: "90 days"}, "invalid min-age"},
+		{"invalid max-age", Filters{MaxAge: "2019-13-01"}, "invalid max-age"},
+	}
+
+	for _, test := range tests {
+		err := test.filters.validate()
+
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: succeeded, want error containing %q", test.name, test.err)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%s: error %q does not contain %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		valid   bool
+	}{
+		{"*.jpg", true},
+		{"/dir/**", true},
+		{"*.{jpg,png}", true},
+		{"file[0-9].txt", true},
+		{"[{]", true},
+		{`\[literal`, true},
+		{"", false},
+		{"file[0-9.txt", false},
+		{"file]", false},
+		{"[[a]]", false},
+		{"*.{jpg,png", false},
+		{"*.jpg}", false},
+	}
+
+	for _, test := range tests {
+		err := validatePattern(test.pattern)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("validatePattern(%q) = %v, want valid %t", test.pattern, err, test.valid)
+		}
+	}
+}
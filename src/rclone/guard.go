@@ -0,0 +1,143 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/process"
+	"github.com/th3noname/backup-and-sync/src/retry"
+)
+
+// DeleteGuard aborts a sync if it would delete more files at the destination than allowed
+type DeleteGuard struct {
+	MaxDelete        int `mapstructure:"max-delete"`
+	MaxDeletePercent int `mapstructure:"max-delete-percent"`
+}
+
+func (g *DeleteGuard) enabled() bool {
+	return g.MaxDelete > 0 || g.MaxDeletePercent > 0
+}
+
+func (g *DeleteGuard) validate() error {
+	if g.MaxDelete < 0 {
+		return errors.New("max-delete must not be negative")
+	}
+
+	if g.MaxDeletePercent < 0 || g.MaxDeletePercent > 100 {
+		return errors.New("max-delete-percent must be between 0 and 100")
+	}
+
+	return nil
+}
+
+// check performs a dry run of the sync with the arguments of the real run and returns an error
+// if the number of deletions exceeds the configured thresholds
+func (g *DeleteGuard) check(ctx context.Context, env *environment, syncArgs []string, destination string, filters Filters, fields log.Fields) error {
+	args := append(append([]string(nil), syncArgs...), "--dry-run")
+
+	deletions, err := countDryRunDeletions(ctx, env, args, fields)
+	if err != nil {
+		return errors.Wrap(err, "sync dry run failed")
+	}
+
+	files, err := countFiles(ctx, env, destination, filters, fields)
+	if err != nil {
+		return errors.Wrap(err, "count destination files failed")
+	}
+
+	percent := 0
+	if files > 0 {
+		percent = int(deletions * 100 / files)
+	}
+
+	log.WithFields(fields).WithFields(log.Fields{
+		"deletions":         deletions,
+		"destination-files": files,
+		"percent":           percent,
+	}).Info("sync dry run finished")
+
+	if g.MaxDelete > 0 && deletions > int64(g.MaxDelete) {
+		return errors.Errorf("sync would delete %d files. At most %d are allowed", deletions, g.MaxDelete)
+	}
+
+	if g.MaxDeletePercent > 0 && percent > g.MaxDeletePercent {
+		return errors.Errorf("sync would delete %d of %d files (%d%%). At most %d%% are allowed", deletions, files, percent, g.MaxDeletePercent)
+	}
+
+	return nil
+}
+
+// countDryRunDeletions runs rclone with --dry-run and counts the files it would delete
+func countDryRunDeletions(ctx context.Context, env *environment, args []string, fields log.Fields) (int64, error) {
+	log.WithFields(fields).WithField("arguments", args).Info("Executing rclone command")
+
+	var stderr bytes.Buffer
+
+	command := env.command(args...)
+	command.Stderr = &stderr
+	err := process.Run(ctx, command)
+
+	// a dry run never transfers files, so --error-on-no-transfer always reports exit code 9
+	if code, ok := retry.ExitCode(err); ok && code == exitNoTransfer {
+		err = nil
+	}
+
+	if err != nil {
+		log.WithFields(fields).Warn(stderr.String())
+		return 0, errors.Wrap(err, "rclone exec failed")
+	}
+
+	var deletions int64
+
+	scanner := bufio.NewScanner(&stderr)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), "Skipped delete as --dry-run is set") {
+			deletions++
+		}
+	}
+
+	return deletions, errors.Wrap(scanner.Err(), "read rclone output failed")
+}
+
+// countFiles returns the number of files at path that match the filter rules
+func countFiles(ctx context.Context, env *environment, path string, filters Filters, fields log.Fields) (int64, error) {
+	args := []string{"size", path, "--json"}
+	args = append(args, filters.args()...)
+
+	out, err := env.executeOutput(ctx, args, fields)
+	if err != nil {
+		return 0, err
+	}
+
+	var size struct {
+		Count int64 `json:"count"`
+	}
+
+	err = json.Unmarshal(out, &size)
+	return size.Count, errors.Wrap(err, "parse rclone size output failed")
+}
@@ -0,0 +1,100 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stats contains the transfer statistics reported by rclone at the end of a job
+type Stats struct {
+	Bytes       int64   `json:"bytes"`
+	Checks      int64   `json:"checks"`
+	Deletes     int64   `json:"deletes"`
+	Errors      int64   `json:"errors"`
+	Transfers   int64   `json:"transfers"`
+	Renames     int64   `json:"renames"`
+	ElapsedTime float64 `json:"elapsedTime"`
+}
+
+// logEntry is a single line written by rclone with --use-json-log
+type logEntry struct {
+	Level  string `json:"level"`
+	Msg    string `json:"msg"`
+	Object string `json:"object"`
+	Stats  *Stats `json:"stats"`
+}
+
+// logLevel maps rclone log levels to logrus levels
+func logLevel(level string) log.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return log.DebugLevel
+	case "info", "notice":
+		return log.InfoLevel
+	case "warn", "warning":
+		return log.WarnLevel
+	default:
+		return log.ErrorLevel
+	}
+}
+
+// forwardLog reads rclone's json log from r and re-emits every entry with the provided fields.
+// The last stats block reported by rclone is returned.
+func forwardLog(r io.Reader, fields log.Fields) (*Stats, error) {
+	var stats *Stats
+
+	logger := log.WithFields(fields)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var entry logEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			logger.Info(line)
+			continue
+		}
+
+		if entry.Stats != nil {
+			stats = entry.Stats
+		}
+
+		l := logger
+		if entry.Object != "" {
+			l = l.WithField("object", entry.Object)
+		}
+
+		l.Log(logLevel(entry.Level), strings.TrimSpace(entry.Msg))
+	}
+
+	return stats, scanner.Err()
+}
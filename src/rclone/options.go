@@ -0,0 +1,167 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Options contains the transfer settings that can be set on every rclone job
+type Options struct {
+	BwLimit     string   `mapstructure:"bw-limit"`
+	Transfers   int      `mapstructure:"transfers"`
+	Checkers    int      `mapstructure:"checkers"`
+	Checksum    bool     `mapstructure:"checksum"`
+	SizeOnly    bool     `mapstructure:"size-only"`
+	Update      bool     `mapstructure:"update"`
+	FastList    bool     `mapstructure:"fast-list"`
+	TpsLimit    float64  `mapstructure:"tpslimit"`
+	MaxTransfer string   `mapstructure:"max-transfer"`
+	CutoffMode  string   `mapstructure:"cutoff-mode"`
+	ExtraArgs   []string `mapstructure:"extra-args"`
+	// ErrorOnNoTransfer records the job with a warning if no file was transferred
+	ErrorOnNoTransfer bool `mapstructure:"error-on-no-transfer"`
+}
+
+// args returns the rclone arguments for the options
+func (o *Options) args() []string {
+	args := []string{"--stats-log-level", "NOTICE", "--stats", "1m"}
+
+	if o.BwLimit != "" {
+		args = append(args, "--bwlimit", o.BwLimit)
+	}
+
+	if o.Transfers > 0 {
+		args = append(args, "--transfers", strconv.Itoa(o.Transfers))
+	}
+
+	if o.Checkers > 0 {
+		args = append(args, "--checkers", strconv.Itoa(o.Checkers))
+	}
+
+	if o.Checksum {
+		args = append(args, "--checksum")
+	}
+
+	if o.SizeOnly {
+		args = append(args, "--size-only")
+	}
+
+	if o.Update {
+		args = append(args, "--update")
+	}
+
+	if o.FastList {
+		args = append(args, "--fast-list")
+	}
+
+	if o.TpsLimit > 0 {
+		args = append(args, "--tpslimit", strconv.FormatFloat(o.TpsLimit, 'f', -1, 64))
+	}
+
+	if o.MaxTransfer != "" {
+		args = append(args, "--max-transfer", o.MaxTransfer)
+	}
+
+	if o.CutoffMode != "" {
+		args = append(args, "--cutoff-mode", o.CutoffMode)
+	}
+
+	if o.ErrorOnNoTransfer {
+		args = append(args, "--error-on-no-transfer")
+	}
+
+	return append(args, o.ExtraArgs...)
+}
+
+// rcConfig returns the options as _config parameter for the remote control API.
+// The bandwidth limit is global in rcd and is set separately.
+func (o *Options) rcConfig(config map[string]interface{}) map[string]interface{} {
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+
+	if o.Transfers > 0 {
+		config["Transfers"] = o.Transfers
+	}
+
+	if o.Checkers > 0 {
+		config["Checkers"] = o.Checkers
+	}
+
+	if o.Checksum {
+		config["CheckSum"] = true
+	}
+
+	if o.SizeOnly {
+		config["SizeOnly"] = true
+	}
+
+	if o.Update {
+		config["UpdateOlder"] = true
+	}
+
+	if o.FastList {
+		config["UseListR"] = true
+	}
+
+	if o.TpsLimit > 0 {
+		config["TPSLimit"] = o.TpsLimit
+	}
+
+	if o.MaxTransfer != "" {
+		config["MaxTransfer"] = o.MaxTransfer
+	}
+
+	if o.CutoffMode != "" {
+		config["CutoffMode"] = o.CutoffMode
+	}
+
+	return config
+}
+
+func (o *Options) validate() error {
+	if o.Transfers < 0 || o.Checkers < 0 || o.TpsLimit < 0 {
+		return errors.New("transfers, checkers and tpslimit must not be negative")
+	}
+
+	if o.Checksum && o.SizeOnly {
+		return errors.New("checksum and size-only can not be combined")
+	}
+
+	if o.MaxTransfer != "" && !sizePattern.MatchString(o.MaxTransfer) {
+		return errors.Errorf("invalid max-transfer \"%s\"", o.MaxTransfer)
+	}
+
+	switch o.CutoffMode {
+	case "":
+	case "hard", "soft", "cautious":
+		if o.MaxTransfer == "" {
+			return errors.New("cutoff-mode requires max-transfer")
+		}
+	default:
+		return errors.Errorf("invalid cutoff-mode \"%s\". Valid modes are hard, soft and cautious", o.CutoffMode)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package rclone
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		err     string
+	}{
+		{"empty", Options{}, ""},
+		{"valid", Options{Transfers: 8, Checkers: 16, TpsLimit: 10, MaxTransfer: "100G", CutoffMode: "soft"}, ""},
+		{"negative transfers", Options{Transfers: -1}, "must not be negative"},
+		{"negative tpslimit", Options{TpsLimit: -0.5}, "must not be negative"},
+		{"checksum and size-only", Options{Checksum: true, SizeOnly: true}, "can not be combined"},
+		{"max-transfer off", Options{MaxTransfer: "off"}, ""},
+		{"max-transfer fraction", Options{MaxTransfer: "1.5T"}, ""},
+		{"invalid max-transfer", Options{MaxTransfer: "100 GB"}, "invalid max-transfer"},
+		{"cutoff-mode without max-transfer", Options{CutoffMode: "hard"}, "requires max-transfer"},
+		{"invalid cutoff-mode", Options{MaxTransfer: "1G", CutoffMode: "gentle"}, "invalid cutoff-mode"},
+	}
+
+	for _, test := range tests {
+		err := test.options.validate()
+
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: succeeded, want error containing %q", test.name, test.err)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%s: error %q does not contain %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestOptionsArgs(t *testing.T) {
+	o := Options{BwLimit: "08:00,600 23:00,off", TpsLimit: 2.5, ExtraArgs: []string{"--order-by", "size,desc"}}
+
+	got := strings.Join(o.args(), " ")
+	want := "--stats-log-level NOTICE --stats 1m --bwlimit 08:00,600 23:00,off --tpslimit 2.5 --order-by size,desc"
+
+	if got != want {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+}
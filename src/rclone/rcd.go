@@ -0,0 +1,420 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/process"
+	"github.com/th3noname/backup-and-sync/src/retry"
+)
+
+// RCD configures the execution of copy and sync jobs through a long-lived rclone rcd process
+// instead of starting one rclone process per job. The bandwidth limit of rcd is global, if jobs
+// run in parallel the limit of the last started job applies to all of them.
+type RCD struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Address      string `mapstructure:"address"`
+	PollInterval string `mapstructure:"poll-interval"`
+	StatsPeriod  string `mapstructure:"stats-period"`
+}
+
+func (c *RCD) validate() error {
+	if c.PollInterval != "" {
+		if _, err := time.ParseDuration(c.PollInterval); err != nil {
+			return errors.Wrap(err, "invalid rcd poll-interval")
+		}
+	}
+
+	if c.StatsPeriod != "" {
+		if _, err := time.ParseDuration(c.StatsPeriod); err != nil {
+			return errors.Wrap(err, "invalid rcd stats-period")
+		}
+	}
+
+	return nil
+}
+
+// rcTimeout limits a single request to the remote control API. Jobs are submitted
+// asynchronously, so every request is answered quickly by a healthy daemon.
+const rcTimeout = time.Minute
+
+// daemon is a running rclone rcd process
+type daemon struct {
+	command      *exec.Cmd
+	client       *http.Client
+	baseURL      string
+	user         string
+	password     string
+	socket       string
+	pollInterval time.Duration
+	statsPeriod  time.Duration
+	exited       chan error
+	logDone      chan struct{}
+}
+
+// startDaemon starts rclone rcd with the environment variables of the inline remotes and waits
+// until the remote control API is available
+func startDaemon(conf RCD, remotes []string) (*daemon, error) {
+	d := &daemon{
+		pollInterval: time.Second,
+		statsPeriod:  time.Minute,
+		exited:       make(chan error, 1),
+		logDone:      make(chan struct{}),
+	}
+
+	if conf.PollInterval != "" {
+		d.pollInterval, _ = time.ParseDuration(conf.PollInterval)
+	}
+
+	if conf.StatsPeriod != "" {
+		d.statsPeriod, _ = time.ParseDuration(conf.StatsPeriod)
+	}
+
+	var err error
+
+	d.user, err = randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	d.password, err = randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	address := conf.Address
+	if address == "" {
+		address, err = freeLocalAddress()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if strings.HasPrefix(address, "unix://") {
+		d.socket = strings.TrimPrefix(address, "unix://")
+		d.baseURL = "http://unix/"
+		d.client = &http.Client{
+			Timeout: rcTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", d.socket)
+				},
+			},
+		}
+	} else {
+		d.baseURL = fmt.Sprintf("http://%s/", address)
+		d.client = &http.Client{Timeout: rcTimeout}
+	}
+
+	args := []string{"rcd", "--rc-addr", address, "--use-json-log"}
+	fields := log.Fields{"rcd": address}
+
+	log.WithFields(fields).Info("Starting rclone rcd")
+
+	d.command = exec.Command("rclone", args...)
+	d.command.Stdout = os.Stdout
+	// the credentials are not passed as arguments where other users could read them
+	d.command.Env = append(os.Environ(), remotes...)
+	d.command.Env = append(d.command.Env, "RCLONE_RC_USER="+d.user, "RCLONE_RC_PASS="+d.password)
+
+	stderr, err := d.command.StderrPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "rclone rcd exec failed")
+	}
+
+	err = process.Start(d.command)
+	if err != nil {
+		return nil, errors.Wrap(err, "rclone rcd exec failed")
+	}
+
+	go func() {
+		forwardLog(stderr, fields)
+		close(d.logDone)
+	}()
+
+	go func() {
+		<-d.logDone
+		d.exited <- d.command.Wait()
+	}()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err = d.call(context.Background(), "rc/noop", map[string]interface{}{}, nil)
+		if err == nil {
+			break
+		}
+
+		select {
+		case exitErr := <-d.exited:
+			return nil, errors.Wrap(exitErr, "rclone rcd exited unexpectedly")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			d.kill()
+			return nil, errors.Wrap(err, "rclone rcd did not become ready")
+		}
+	}
+
+	log.WithFields(fields).Info("rclone rcd is ready")
+	return d, nil
+}
+
+// stop shuts the daemon down. It is killed if it does not exit within 10 seconds.
+func (d *daemon) stop() {
+	log.Info("Stopping rclone rcd")
+
+	// the daemon may exit before it answers the request
+	d.call(context.Background(), "core/quit", map[string]interface{}{}, nil)
+
+	select {
+	case <-d.exited:
+	case <-time.After(10 * time.Second):
+		log.Warn("rclone rcd did not exit. Killing process")
+		d.kill()
+	}
+
+	if d.socket != "" {
+		os.Remove(d.socket)
+	}
+}
+
+func (d *daemon) kill() {
+	d.command.Process.Kill()
+	<-d.exited
+}
+
+// call executes a remote control command and decodes the response into out if out is not nil.
+// The request is aborted if ctx is cancelled.
+func (d *daemon) call(ctx context.Context, method string, params map[string]interface{}, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err, "encode rc request failed")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.baseURL+method, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create rc request failed")
+	}
+
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(d.user, d.password)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "rc %s failed", method)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read rc %s response failed", method)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var rcErr struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &rcErr)
+
+		return errors.Errorf("rc %s failed with status %d: %s", method, resp.StatusCode, rcErr.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return errors.Wrapf(json.Unmarshal(data, out), "decode rc %s response failed", method)
+}
+
+type rcJobStatus struct {
+	Finished bool   `json:"finished"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error"`
+}
+
+// runJob submits an async job, waits until it is finished and stores the statistics in the result
+func (d *daemon) runJob(ctx context.Context, method string, params map[string]interface{}, bwLimit string, res *Result) error {
+	rate := bwLimit
+	if rate == "" {
+		rate = "off"
+	}
+
+	err := d.call(ctx, "core/bwlimit", map[string]interface{}{"rate": rate}, nil)
+	if err != nil {
+		return errors.Wrap(err, "set bandwidth limit failed")
+	}
+
+	params["_async"] = true
+
+	log.WithFields(res.Fields).WithField("method", method).Info("Submitting rclone rc job")
+
+	var job struct {
+		JobID int64 `json:"jobid"`
+	}
+
+	err = d.call(ctx, method, params, &job)
+	if err != nil {
+		return err
+	}
+
+	group := fmt.Sprintf("job/%d", job.JobID)
+	fields := log.Fields{"jobid": job.JobID}
+	lastStats := time.Now()
+
+	var status rcJobStatus
+
+	stop := func() error {
+		log.WithFields(res.Fields).WithFields(fields).Warn("stopping rclone rc job")
+
+		// ctx is already done, the request is limited by the client timeout
+		err := d.call(context.Background(), "job/stop", map[string]interface{}{"jobid": job.JobID}, nil)
+		if err != nil {
+			log.WithError(err).WithFields(res.Fields).WithFields(fields).Warn("stopping rclone rc job failed")
+		}
+
+		return errors.Wrapf(ctx.Err(), "rclone rc job %d cancelled", job.JobID)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return stop()
+		case <-time.After(d.pollInterval):
+		}
+
+		err = d.call(ctx, "job/status", map[string]interface{}{"jobid": job.JobID}, &status)
+		if err != nil && ctx.Err() != nil {
+			return stop()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if status.Finished {
+			break
+		}
+
+		if time.Since(lastStats) >= d.statsPeriod {
+			lastStats = time.Now()
+
+			var stats Stats
+			if err := d.call(ctx, "core/stats", map[string]interface{}{"group": group}, &stats); err == nil {
+				log.WithFields(res.Fields).WithFields(fields).WithFields(log.Fields{
+					"bytes":     stats.Bytes,
+					"transfers": stats.Transfers,
+					"errors":    stats.Errors,
+					"elapsed":   stats.ElapsedTime,
+				}).Info("rclone rc job progress")
+			}
+		}
+	}
+
+	var stats rcStats
+	if err := d.call(ctx, "core/stats", map[string]interface{}{"group": group}, &stats); err == nil {
+		res.Stats = &stats.Stats
+	} else {
+		stats.FatalError = true
+	}
+
+	if !status.Success {
+		return classifyRcJob(errors.Errorf("rclone rc job %d failed: %s", job.JobID, status.Error), stats)
+	}
+
+	log.WithFields(res.Fields).WithFields(fields).Info("rclone rc job finished successfully")
+	return nil
+}
+
+// rcStats are the statistics of core/stats including the error classification of rclone
+type rcStats struct {
+	Stats
+	FatalError bool `json:"fatalError"`
+	RetryError bool `json:"retryError"`
+}
+
+// classifyRcJob sets the class of a failed rc job like classify does for the exit code of an
+// rclone process. rclone retries errors that are not fatal itself. Those errors are retried,
+// fatal errors and errors rclone would not retry fail the job.
+func classifyRcJob(err error, stats rcStats) error {
+	if stats.RetryError && !stats.FatalError {
+		return retry.Classify(err, retry.Retryable)
+	}
+
+	return retry.Classify(err, retry.Fatal)
+}
+
+// rcParams returns the parameters for sync/copy and sync/sync
+func rcParams(source string, destination string, filters Filters, config map[string]interface{}) map[string]interface{} {
+	params := map[string]interface{}{
+		"srcFs": source,
+		"dstFs": destination,
+	}
+
+	if f := filters.rcFilter(); len(f) > 0 {
+		params["_filter"] = f
+	}
+
+	if len(config) > 0 {
+		params["_config"] = config
+	}
+
+	return params
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "generate random credentials failed")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// freeLocalAddress returns a localhost address with a currently unused port
+func freeLocalAddress() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", errors.Wrap(err, "find free port failed")
+	}
+	defer l.Close()
+
+	return l.Addr().String(), nil
+}
@@ -21,95 +21,418 @@
 package rclone
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/process"
+	"github.com/th3noname/backup-and-sync/src/retry"
 )
 
-type job interface {
-	run() error
+// Job is a single rclone job. It can be executed with Rclone.RunJob.
+type Job interface {
+	run(context.Context, *environment, *Result) error
 	name() string
 	continueOnError() bool
+	timeout() time.Duration
+	retryPolicy() retry.Policy
 	logFields() log.Fields
+	validate() error
+	paths() []string
+}
+
+// environment contains the resources shared by the jobs of a run
+type environment struct {
+	daemon *daemon
+	state  *state
+	// remotes are the environment variables defining the inline remotes
+	remotes []string
+}
+
+// command returns an rclone command that has access to the inline remotes
+func (e *environment) command(arguments ...string) *exec.Cmd {
+	command := exec.Command("rclone", arguments...)
+	command.Env = append(os.Environ(), e.remotes...)
+
+	return command
 }
 
 // Config contains the information on all actions that should be performed
 type Config struct {
-	Copy []Copy `mapstructure:"copy"`
-	Sync []Sync `mapstructure:"sync"`
+	Copy       []Copy            `mapstructure:"copy"`
+	Sync       []Sync            `mapstructure:"sync"`
+	Move       []Move            `mapstructure:"move"`
+	Check      []Check           `mapstructure:"check"`
+	CryptCheck []CryptCheck      `mapstructure:"cryptcheck"`
+	Bisync     []Bisync          `mapstructure:"bisync"`
+	StateDir   string            `mapstructure:"state-dir"`
+	RCD        RCD               `mapstructure:"rcd"`
+	Remotes    map[string]Remote `mapstructure:"remotes"`
+	// RemoteLimits limits the number of jobs that access a remote at the same time
+	RemoteLimits map[string]int `mapstructure:"remote-limits"`
+}
+
+// Validate checks the configuration of all jobs
+func (c *Config) Validate() error {
+	if err := c.RCD.validate(); err != nil {
+		return err
+	}
+
+	for name, remote := range c.Remotes {
+		if err := remote.validate(name); err != nil {
+			return err
+		}
+	}
+
+	for _, j := range c.Jobs() {
+		if err := c.ValidateJob(j); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateJob checks the configuration of a single job
+func (c *Config) ValidateJob(j Job) error {
+	if err := j.validate(); err != nil {
+		return errors.Wrapf(err, "invalid %s job (%v)", j.name(), j.logFields())
+	}
+
+	if !c.RCD.Enabled {
+		return nil
+	}
+
+	var o *Options
+
+	switch v := j.(type) {
+	case *Copy:
+		o = &v.Options
+	case *Sync:
+		o = &v.Options
+	default:
+		return nil
+	}
+
+	if len(o.ExtraArgs) > 0 {
+		return errors.Errorf("invalid %s job (%v): extra-args are not supported in rcd mode", j.name(), j.logFields())
+	}
+
+	if o.ErrorOnNoTransfer {
+		return errors.Errorf("invalid %s job (%v): error-on-no-transfer is not supported in rcd mode", j.name(), j.logFields())
+	}
+
+	return nil
+}
+
+// Jobs returns all jobs configured in the copy, sync, move, check, cryptcheck and bisync lists
+func (c *Config) Jobs() []Job {
+	var jobs []Job
+
+	for i := range c.Copy {
+		jobs = append(jobs, &c.Copy[i])
+	}
+
+	for i := range c.Sync {
+		jobs = append(jobs, &c.Sync[i])
+	}
+
+	for i := range c.Move {
+		jobs = append(jobs, &c.Move[i])
+	}
+
+	for i := range c.Check {
+		jobs = append(jobs, &c.Check[i])
+	}
+
+	for i := range c.CryptCheck {
+		jobs = append(jobs, &c.CryptCheck[i])
+	}
+
+	for i := range c.Bisync {
+		jobs = append(jobs, &c.Bisync[i])
+	}
+
+	return jobs
+}
+
+// Result contains the outcome of a single job
+type Result struct {
+	Job         string
+	Fields      log.Fields
+	Differences *Differences
+	Stats       *Stats
+	Err         error
 }
 
 // Rclone is a CLI wrapper
 type Rclone struct {
-	config *Config
+	config  *Config
+	env     environment
+	started bool
+	mutex   *sync.Mutex
 }
 
 // New creates a Rclone wrapper instance for the provided config
 func New(conf *Config) Rclone {
-	return Rclone{config: conf}
+	return Rclone{config: conf, mutex: &sync.Mutex{}}
 }
 
-// Run the configured rclone jobs
-func (r *Rclone) Run() error {
-	for _, v := range r.config.Copy {
-		err := r.callJob(&v)
+// start defines the inline remotes. It is called by prepare before the first job.
+func (r *Rclone) start() error {
+	remotes, err := remotesEnv(r.config.Remotes)
+	if err != nil {
+		return errors.Wrap(err, "define rclone remotes failed")
+	}
 
-		if err != nil {
-			return err
+	r.env.remotes = remotes
+
+	r.started = true
+	return nil
+}
+
+// Stop shuts down the rclone rcd process if it was started
+func (r *Rclone) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.env.daemon != nil {
+		r.env.daemon.stop()
+		r.env.daemon = nil
+	}
+}
+
+// prepare starts the resources needed by the job on first use and returns them
+func (r *Rclone) prepare(j Job) (environment, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.started {
+		if err := r.start(); err != nil {
+			return r.env, err
 		}
 	}
 
-	for _, v := range r.config.Sync {
-		err := r.callJob(&v)
+	switch j.(type) {
+	case *Copy, *Sync:
+		if r.config.RCD.Enabled && r.env.daemon == nil {
+			d, err := startDaemon(r.config.RCD, r.env.remotes)
+			if err != nil {
+				return r.env, errors.Wrap(err, "start rclone rcd failed")
+			}
 
-		if err != nil {
-			return err
+			r.env.daemon = d
+		}
+	case *Bisync:
+		if r.env.state == nil {
+			s, err := loadState(r.config.StateDir)
+			if err != nil {
+				return r.env, errors.Wrap(err, "load rclone state failed")
+			}
+
+			r.env.state = s
 		}
 	}
 
-	return nil
+	return r.env, nil
 }
 
-func (r *Rclone) callJob(j job) error {
-	err := j.run()
+// RunJob executes a single job and returns its result. It is safe to run multiple jobs
+// concurrently. The rclone process is terminated if ctx is cancelled.
+func (r *Rclone) RunJob(ctx context.Context, j Job) (Result, error) {
+	res := Result{Job: j.name(), Fields: j.logFields()}
+	res.Fields["job"] = j.name()
 
-	if err != nil {
-		if j.continueOnError() {
-			log.WithError(err).WithFields(j.logFields()).Warnf("run %s job failed. Continuing...", j.name())
-			return nil
+	env, err := r.prepare(j)
+	if err == nil {
+		err = j.run(ctx, &env, &res)
+	}
+
+	res.Err = err
+
+	if res.Stats != nil {
+		log.WithFields(res.Fields).WithFields(log.Fields{
+			"bytes":     res.Stats.Bytes,
+			"transfers": res.Stats.Transfers,
+			"errors":    res.Stats.Errors,
+			"elapsed":   res.Stats.ElapsedTime,
+		}).Infof("rclone %s statistics", j.name())
+	}
+
+	return res, errors.Wrapf(err, "run %s job failed", j.name())
+}
+
+// Remotes returns the names of the remotes the job accesses. Local paths are reported as "local".
+func Remotes(j Job) []string {
+	var remotes []string
+
+	seen := make(map[string]bool)
+
+	for _, p := range j.paths() {
+		name := remoteName(p)
+		if !seen[name] {
+			seen[name] = true
+			remotes = append(remotes, name)
 		}
+	}
+
+	return remotes
+}
+
+// Paths returns the local and remote paths the job accesses
+func Paths(j Job) []string {
+	return j.paths()
+}
 
-		return errors.Wrapf(err, "run %s job failed", j.name())
+// remoteName returns the remote of an rclone path (e.g. "remote" for "remote:path")
+func remoteName(path string) string {
+	start := 0
+	if strings.HasPrefix(path, ":") {
+		// connection string like ":s3,provider=AWS:bucket"
+		start = 1
 	}
 
-	return nil
+	i := strings.Index(path[start:], ":")
+	if i < 0 {
+		return "local"
+	}
+
+	name := path[:start+i]
+
+	// windows drive letters and paths containing a colon are local
+	if strings.ContainsAny(name, `/\`) || (runtime.GOOS == "windows" && len(name) == 1) {
+		return "local"
+	}
+
+	return name
 }
 
-func execute(arguments []string) error {
-	log.WithField("arguments", arguments).Info("Executing rclone command")
+// ContinueOnError reports whether the remaining jobs are executed if the job fails
+func ContinueOnError(j Job) bool {
+	return j.continueOnError()
+}
 
-	w := log.StandardLogger().Writer()
+// Timeout returns the maximum duration of the job. Zero means no limit.
+func Timeout(j Job) time.Duration {
+	return j.timeout()
+}
 
-	command := exec.Command("rclone", arguments...)
+// RetryPolicy returns how often and when the job is retried after a retryable error
+func RetryPolicy(j Job) retry.Policy {
+	return j.retryPolicy()
+}
+
+// execute runs rclone with json logging enabled and forwards the log entries with the job fields.
+// The final transfer statistics are stored in the result if res is not nil.
+func (e *environment) execute(ctx context.Context, arguments []string, res *Result) error {
+	fields := log.Fields{}
+	if res != nil {
+		fields = res.Fields
+	}
+
+	arguments = append(arguments, "--use-json-log")
+
+	log.WithFields(fields).WithField("arguments", arguments).Info("Executing rclone command")
+
+	command := e.command(arguments...)
 	command.Stdout = os.Stdout
-	command.Stderr = w
-	err := command.Run()
+
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		return errors.Wrap(err, "rclone exec failed")
+	}
+
+	err = process.Start(command)
+	if err != nil {
+		return errors.Wrap(err, "rclone exec failed")
+	}
+
+	stop := process.Watch(ctx, command)
+
+	stats, logErr := forwardLog(stderr, fields)
+	if logErr != nil {
+		log.WithError(logErr).WithFields(fields).Warn("reading rclone log failed")
+		io.Copy(ioutil.Discard, stderr)
+	}
+
+	err = command.Wait()
+	stop()
+
+	if res != nil && stats != nil {
+		res.Stats = stats
+	}
 
 	if err == nil {
-		log.Info("rclone exited with return code 0")
+		log.WithFields(fields).Info("rclone exited with return code 0")
+	}
+
+	return errors.Wrap(classify(err), "rclone exec failed")
+}
+
+// rclone exit codes as defined in lib/exitcode
+const (
+	exitUncategorized = 1
+	exitUsage         = 2
+	exitDirNotFound   = 3
+	exitTemporary     = 5
+	exitNoTransfer    = 9
+)
+
+// classify sets the class of a failed rclone execution. Temporary and uncategorized errors are
+// retried. If no files were transferred (only with error-on-no-transfer) the job finished with
+// a warning. All other errors like usage errors, missing files or an exceeded max-transfer are
+// fatal.
+func classify(err error) error {
+	code, ok := retry.ExitCode(err)
+	if !ok {
+		return err
 	}
 
-	return errors.Wrap(err, "rclone exec failed")
+	switch code {
+	case exitUncategorized, exitTemporary:
+		return retry.Classify(err, retry.Retryable)
+	case exitUsage:
+		return retry.Classify(errors.Wrap(err, "invalid rclone arguments"), retry.Fatal)
+	case exitNoTransfer:
+		return retry.Classify(errors.Wrap(err, "no files transferred"), retry.Warning)
+	default:
+		return retry.Classify(err, retry.Fatal)
+	}
+}
+
+// executeOutput runs rclone and returns the output written to stdout. Messages on stderr are
+// logged with the fields of the job.
+func (e *environment) executeOutput(ctx context.Context, arguments []string, fields log.Fields) ([]byte, error) {
+	logger := log.WithFields(fields)
+	logger.WithField("arguments", arguments).Debug("Executing rclone command")
+
+	w := logger.WriterLevel(log.WarnLevel)
+	defer w.Close()
+
+	command := e.command(arguments...)
+	command.Stderr = w
+	out, err := process.Output(ctx, command)
+
+	return out, errors.Wrap(classify(err), "rclone exec failed")
 }
 
 // Copy represents a single rclone copy job
 type Copy struct {
-	Source          string `mapstructure:"source"`
-	Destination     string `mapstructure:"destination"`
-	BwLimit         string `mapstructure:"bw-limit"`
-	ContinueOnError bool   `mapstructure:"continue-on-error"`
+	Source          string        `mapstructure:"source"`
+	Destination     string        `mapstructure:"destination"`
+	Options         Options       `mapstructure:",squash"`
+	Filters         Filters       `mapstructure:",squash"`
+	ContinueOnError bool          `mapstructure:"continue-on-error"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	Retry           retry.Policy  `mapstructure:",squash"`
 }
 
 func (c *Copy) name() string {
@@ -120,6 +443,26 @@ func (c *Copy) continueOnError() bool {
 	return c.ContinueOnError
 }
 
+func (c *Copy) timeout() time.Duration {
+	return c.Timeout
+}
+
+func (c *Copy) retryPolicy() retry.Policy {
+	return c.Retry
+}
+
+func (c *Copy) validate() error {
+	if err := c.Options.validate(); err != nil {
+		return err
+	}
+
+	return c.Filters.validate()
+}
+
+func (c *Copy) paths() []string {
+	return []string{c.Source, c.Destination}
+}
+
 func (c *Copy) logFields() log.Fields {
 	return log.Fields{
 		"source":      c.Source,
@@ -127,31 +470,40 @@ func (c *Copy) logFields() log.Fields {
 	}
 }
 
-func (c *Copy) run() error {
+func (c *Copy) run(ctx context.Context, env *environment, res *Result) error {
 	log.WithFields(c.logFields()).Infof("start run rclone %s", c.name())
 
+	if env.daemon != nil {
+		err := env.daemon.runJob(ctx, "sync/copy", rcParams(c.Source, c.Destination, c.Filters, c.Options.rcConfig(nil)), c.Options.BwLimit, res)
+
+		log.WithFields(c.logFields()).Infof("end run rclone %s", c.name())
+		return errors.Wrap(err, "rc job failed")
+	}
+
 	args := []string{c.name()}
 	args = append(args, c.Source)
 	args = append(args, c.Destination)
-	args = append(args, "--stats-log-level", "NOTICE")
-	args = append(args, "--stats", "1m")
+	args = append(args, c.Options.args()...)
 
-	if c.BwLimit != "" {
-		args = append(args, "--bwlimit", c.BwLimit)
-	}
+	args = append(args, c.Filters.args()...)
 
-	err := execute(args)
+	err := env.execute(ctx, args, res)
 
-	log.Infof("end run  rclone %s", c.name())
+	log.WithFields(c.logFields()).Infof("end run rclone %s", c.name())
 	return errors.Wrap(err, "execute failed")
 }
 
 // Sync represents a single rclone copy job
 type Sync struct {
-	Source          string `mapstructure:"source"`
-	Destination     string `mapstructure:"destination"`
-	BwLimit         string `mapstructure:"bw-limit"`
-	ContinueOnError bool   `mapstructure:"continue-on-error"`
+	Source          string        `mapstructure:"source"`
+	Destination     string        `mapstructure:"destination"`
+	KeepDeleted     KeepDeleted   `mapstructure:"keep-deleted"`
+	DeleteGuard     DeleteGuard   `mapstructure:",squash"`
+	Options         Options       `mapstructure:",squash"`
+	Filters         Filters       `mapstructure:",squash"`
+	ContinueOnError bool          `mapstructure:"continue-on-error"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	Retry           retry.Policy  `mapstructure:",squash"`
 }
 
 func (s *Sync) name() string {
@@ -162,6 +514,34 @@ func (s *Sync) continueOnError() bool {
 	return s.ContinueOnError
 }
 
+func (s *Sync) timeout() time.Duration {
+	return s.Timeout
+}
+
+func (s *Sync) retryPolicy() retry.Policy {
+	return s.Retry
+}
+
+func (s *Sync) validate() error {
+	if err := s.KeepDeleted.validate(); err != nil {
+		return err
+	}
+
+	if err := s.DeleteGuard.validate(); err != nil {
+		return err
+	}
+
+	if err := s.Options.validate(); err != nil {
+		return err
+	}
+
+	return s.Filters.validate()
+}
+
+func (s *Sync) paths() []string {
+	return []string{s.Source, s.Destination}
+}
+
 func (s *Sync) logFields() log.Fields {
 	return log.Fields{
 		"source":      s.Source,
@@ -169,21 +549,134 @@ func (s *Sync) logFields() log.Fields {
 	}
 }
 
-func (s *Sync) run() error {
-	log.WithFields(s.logFields()).Infof("start run rclone %s", s.name())
-
+// args returns the arguments shared by the sync and the dry run of the delete guard. The archive
+// arguments of keep-deleted are not included because rclone reports moves instead of deletions
+// for them.
+func (s *Sync) args() []string {
 	args := []string{s.name()}
 	args = append(args, s.Source)
 	args = append(args, s.Destination)
-	args = append(args, "--stats-log-level", "NOTICE")
-	args = append(args, "--stats", "1m")
+	args = append(args, s.Options.args()...)
+
+	return append(args, s.Filters.args()...)
+}
+
+func (s *Sync) run(ctx context.Context, env *environment, res *Result) error {
+	log.WithFields(s.logFields()).Infof("start run rclone %s", s.name())
+
+	if s.DeleteGuard.enabled() {
+		err := s.DeleteGuard.check(ctx, env, s.args(), s.Destination, s.Filters, s.logFields())
+		if err != nil {
+			log.WithFields(s.logFields()).Infof("end run rclone %s", s.name())
+			return errors.Wrap(err, "delete guard aborted sync")
+		}
+	}
+
+	now := time.Now()
+
+	var err error
+
+	// in rcd mode the options are passed as _config, extra-args are rejected by ValidateJob
+	if env.daemon != nil {
+		var config map[string]interface{}
+		if s.KeepDeleted.enabled() {
+			config = s.KeepDeleted.rcConfig(now)
+		}
+
+		err = env.daemon.runJob(ctx, "sync/sync", rcParams(s.Source, s.Destination, s.Filters, s.Options.rcConfig(config)), s.Options.BwLimit, res)
+	} else {
+		args := s.args()
+		if s.KeepDeleted.enabled() {
+			args = append(args, s.KeepDeleted.args(now)...)
+		}
+
+		err = env.execute(ctx, args, res)
+	}
+
+	if err != nil {
+		log.WithFields(s.logFields()).Infof("end run rclone %s", s.name())
+		return errors.Wrap(err, "execute failed")
+	}
+
+	if s.KeepDeleted.enabled() {
+		err = s.KeepDeleted.cleanup(ctx, env, now, res.Fields)
+	}
+
+	log.WithFields(s.logFields()).Infof("end run rclone %s", s.name())
+	return errors.Wrap(err, "archive cleanup failed")
+}
+
+// Move represents a single rclone move job
+type Move struct {
+	Source             string        `mapstructure:"source"`
+	Destination        string        `mapstructure:"destination"`
+	DeleteEmptySrcDirs bool          `mapstructure:"delete-empty-src-dirs"`
+	DryRun             bool          `mapstructure:"dry-run"`
+	Options            Options       `mapstructure:",squash"`
+	Filters            Filters       `mapstructure:",squash"`
+	ContinueOnError    bool          `mapstructure:"continue-on-error"`
+	Timeout            time.Duration `mapstructure:"timeout"`
+	Retry              retry.Policy  `mapstructure:",squash"`
+}
+
+func (m *Move) name() string {
+	return "move"
+}
+
+func (m *Move) continueOnError() bool {
+	return m.ContinueOnError
+}
+
+func (m *Move) timeout() time.Duration {
+	return m.Timeout
+}
+
+func (m *Move) retryPolicy() retry.Policy {
+	return m.Retry
+}
+
+func (m *Move) validate() error {
+	if err := m.Options.validate(); err != nil {
+		return err
+	}
+
+	return m.Filters.validate()
+}
+
+func (m *Move) paths() []string {
+	return []string{m.Source, m.Destination}
+}
+
+func (m *Move) logFields() log.Fields {
+	return log.Fields{
+		"source":      m.Source,
+		"destination": m.Destination,
+		"min-age":     m.Filters.MinAge,
+		"max-age":     m.Filters.MaxAge,
+		"dry-run":     m.DryRun,
+	}
+}
+
+func (m *Move) run(ctx context.Context, env *environment, res *Result) error {
+	log.WithFields(m.logFields()).Infof("start run rclone %s", m.name())
+
+	args := []string{m.name()}
+	args = append(args, m.Source)
+	args = append(args, m.Destination)
+	args = append(args, m.Options.args()...)
+
+	args = append(args, m.Filters.args()...)
+
+	if m.DeleteEmptySrcDirs {
+		args = append(args, "--delete-empty-src-dirs")
+	}
 
-	if s.BwLimit != "" {
-		args = append(args, "--bwlimit", s.BwLimit)
+	if m.DryRun {
+		args = append(args, "--dry-run")
 	}
 
-	err := execute(args)
+	err := env.execute(ctx, args, res)
 
-	log.Infof("end run rclone %s", s.name())
+	log.WithFields(m.logFields()).Infof("end run rclone %s", m.name())
 	return errors.Wrap(err, "execute failed")
 }
@@ -0,0 +1,57 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package rclone
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRemoteName(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"remote:path/to/dir", "remote"},
+		{"remote:", "remote"},
+		{"GDrive:Photos", "GDrive"},
+		{":s3,provider=AWS:bucket/path", ":s3,provider=AWS"},
+		{"/local/path", "local"},
+		{"relative/path", "local"},
+		{"/path/with:colon", "local"},
+		{"./dir:name", "local"},
+		{"", "local"},
+	}
+
+	for _, test := range tests {
+		if got := remoteName(test.path); got != test.want {
+			t.Errorf("remoteName(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+
+	// a single letter is a remote on unix, but a drive letter on windows
+	want := "C"
+	if runtime.GOOS == "windows" {
+		want = "local"
+	}
+
+	if got := remoteName(`C:\backup`); got != want {
+		t.Errorf("remoteName(%q) = %q, want %q", `C:\backup`, got, want)
+	}
+}
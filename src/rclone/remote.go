@@ -0,0 +1,204 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rclone
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var remoteNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// passwordKeys are the remote parameters rclone expects to be obscured
+var passwordKeys = map[string]bool{
+	"pass":      true,
+	"password":  true,
+	"password2": true,
+}
+
+// Remote defines an rclone remote that is passed to rclone via environment variables
+type Remote struct {
+	Type       string            `mapstructure:"type"`
+	Parameters map[string]string `mapstructure:"parameters"`
+	Secrets    map[string]Secret `mapstructure:"secrets"`
+}
+
+// Secret is a remote parameter that is read from a file or an environment variable
+type Secret struct {
+	Value   string `mapstructure:"value"`
+	File    string `mapstructure:"file"`
+	Env     string `mapstructure:"env"`
+	Obscure *bool  `mapstructure:"obscure"`
+}
+
+func (s *Secret) validate() error {
+	sources := 0
+
+	for _, v := range []string{s.Value, s.File, s.Env} {
+		if v != "" {
+			sources++
+		}
+	}
+
+	if sources != 1 {
+		return errors.New("exactly one of value, file or env is required")
+	}
+
+	return nil
+}
+
+// resolve returns the secret value
+func (s *Secret) resolve() (string, error) {
+	switch {
+	case s.File != "":
+		data, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", errors.Wrap(err, "read secret file failed")
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", errors.Errorf("environment variable \"%s\" is not set", s.Env)
+		}
+
+		return v, nil
+	default:
+		return s.Value, nil
+	}
+}
+
+// obscure reports whether the secret must be obscured before it is passed to rclone
+func (s *Secret) obscure(key string) bool {
+	if s.Obscure != nil {
+		return *s.Obscure
+	}
+
+	return passwordKeys[strings.ToLower(key)]
+}
+
+func (r *Remote) validate(name string) error {
+	if !remoteNamePattern.MatchString(name) {
+		return errors.Errorf("invalid remote name \"%s\". Only letters, numbers and underscores are allowed", name)
+	}
+
+	if r.Type == "" {
+		return errors.Errorf("remote \"%s\" has no type", name)
+	}
+
+	// parameters are passed to rclone as they are, but rclone only accepts obscured passwords
+	for key := range r.Parameters {
+		if passwordKeys[strings.ToLower(key)] {
+			return errors.Errorf("remote \"%s\" defines the password \"%s\" as parameter. Passwords must be secrets, use obscure: false for obscured values", name, key)
+		}
+	}
+
+	for key, secret := range r.Secrets {
+		if _, exists := r.Parameters[key]; exists {
+			return errors.Errorf("remote \"%s\" defines \"%s\" as parameter and secret", name, key)
+		}
+
+		if err := secret.validate(); err != nil {
+			return errors.Wrapf(err, "invalid secret \"%s\" of remote \"%s\"", key, name)
+		}
+	}
+
+	return nil
+}
+
+// env returns the RCLONE_CONFIG_<NAME>_<KEY> environment variables defining the remote
+func (r *Remote) env(name string) ([]string, error) {
+	prefix := "RCLONE_CONFIG_" + strings.ToUpper(name) + "_"
+
+	env := []string{prefix + "TYPE=" + r.Type}
+
+	for key, value := range r.Parameters {
+		env = append(env, prefix+envKey(key)+"="+value)
+	}
+
+	for key, secret := range r.Secrets {
+		value, err := secret.resolve()
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve secret \"%s\" of remote \"%s\" failed", key, name)
+		}
+
+		if secret.obscure(key) {
+			value, err = obscure(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "obscure secret \"%s\" of remote \"%s\" failed", key, name)
+			}
+		}
+
+		env = append(env, prefix+envKey(key)+"="+value)
+	}
+
+	sort.Strings(env)
+	return env, nil
+}
+
+func envKey(key string) string {
+	return strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// obscure obscures a password with rclone obscure. The value is passed on stdin so
+// it does not show up in the process list.
+func obscure(value string) (string, error) {
+	command := exec.Command("rclone", "obscure", "-")
+	command.Stdin = strings.NewReader(value)
+
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+
+	out, err := command.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "rclone obscure failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// remotesEnv returns the environment variables of all inline remotes. They are only passed to
+// rclone processes, so the credentials do not leak to restic or other child processes.
+func remotesEnv(remotes map[string]Remote) ([]string, error) {
+	var vars []string
+
+	for name, remote := range remotes {
+		env, err := remote.env(name)
+		if err != nil {
+			return nil, err
+		}
+
+		vars = append(vars, env...)
+
+		log.WithField("remote", name).Infof("defined rclone remote of type %s", remote.Type)
+	}
+
+	return vars, nil
+}
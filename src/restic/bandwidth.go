@@ -0,0 +1,124 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package restic
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type bandwidthEntry struct {
+	minutes int
+	limit   int
+}
+
+// bandwidthLimit returns the bandwidth limit in KiB/s that is active at the provided time.
+// The limit is either a single value (e.g. 600) or a time table in the same format rclone
+// uses for --bwlimit (e.g. "08:00,600 23:00,off"). A value of "off" or 0 means no limit.
+func bandwidthLimit(schedule string, now time.Time) (int, error) {
+	schedule = strings.TrimSpace(schedule)
+	if schedule == "" {
+		return 0, nil
+	}
+
+	if !strings.Contains(schedule, ",") {
+		return parseBandwidth(schedule)
+	}
+
+	var entries []bandwidthEntry
+
+	for _, v := range strings.Fields(schedule) {
+		parts := strings.SplitN(v, ",", 2)
+		if len(parts) != 2 {
+			return 0, errors.Errorf("invalid time table entry \"%s\". Expected format HH:MM,limit", v)
+		}
+
+		t, err := time.Parse("15:04", parts[0])
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid time \"%s\"", parts[0])
+		}
+
+		limit, err := parseBandwidth(parts[1])
+		if err != nil {
+			return 0, err
+		}
+
+		entries = append(entries, bandwidthEntry{minutes: t.Hour()*60 + t.Minute(), limit: limit})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].minutes < entries[j].minutes
+	})
+
+	// before the first entry of the day the last entry of the previous day is still active
+	current := entries[len(entries)-1]
+	minutes := now.Hour()*60 + now.Minute()
+
+	for _, v := range entries {
+		if v.minutes <= minutes {
+			current = v
+		}
+	}
+
+	return current.limit, nil
+}
+
+// parseBandwidth parses a single bandwidth value in KiB/s. The suffixes B, K, M and G
+// can be used to specify a different unit.
+func parseBandwidth(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" {
+		return 0, nil
+	}
+
+	multiplier := 1.0
+	if l := len(value); l > 0 {
+		switch strings.ToUpper(value[l-1:]) {
+		case "B":
+			multiplier = 1.0 / 1024
+			value = value[:l-1]
+		case "K":
+			value = value[:l-1]
+		case "M":
+			multiplier = 1024
+			value = value[:l-1]
+		case "G":
+			multiplier = 1024 * 1024
+			value = value[:l-1]
+		}
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("invalid bandwidth \"%s\"", value)
+	}
+
+	limit := int(v * multiplier)
+	if limit == 0 && v > 0 {
+		limit = 1
+	}
+
+	return limit, nil
+}
@@ -0,0 +1,99 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package restic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		valid bool
+	}{
+		{"600", 600, true},
+		{" 600 ", 600, true},
+		{"off", 0, true},
+		{"0", 0, true},
+		{"600K", 600, true},
+		{"600k", 600, true},
+		{"2M", 2048, true},
+		{"1.5M", 1536, true},
+		{"1G", 1024 * 1024, true},
+		{"2048B", 2, true},
+		{"100B", 1, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"fast", 0, false},
+		{"1T", 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseBandwidth(test.value)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("parseBandwidth(%q) error = %v, want valid %t", test.value, err, test.valid)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("parseBandwidth(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2019, 5, 1, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		schedule string
+		now      time.Time
+		want     int
+		valid    bool
+	}{
+		{"", at(12, 0), 0, true},
+		{"600", at(12, 0), 600, true},
+		{"off", at(12, 0), 0, true},
+		{"08:00,600 23:00,off", at(7, 59), 0, true},
+		{"08:00,600 23:00,off", at(8, 0), 600, true},
+		{"08:00,600 23:00,off", at(22, 59), 600, true},
+		{"08:00,600 23:00,off", at(23, 0), 0, true},
+		{"23:00,off 08:00,1M", at(12, 0), 1024, true},
+		{"08:00,600 12:00,1M 18:00,300", at(3, 0), 300, true},
+		{"08:00,600 23:00", at(12, 0), 0, false},
+		{"25:00,600", at(12, 0), 0, false},
+		{"08:00,fast", at(12, 0), 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := bandwidthLimit(test.schedule, test.now)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("bandwidthLimit(%q, %s) error = %v, want valid %t", test.schedule, test.now.Format("15:04"), err, test.valid)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("bandwidthLimit(%q, %s) = %d, want %d", test.schedule, test.now.Format("15:04"), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Preconditions are checked before a backup is started. If a precondition fails the backup
+// is not started and forget jobs on the same repository are skipped for the current run.
+type Preconditions struct {
+	Mountpoint bool   `mapstructure:"mountpoint"`
+	CanaryFile string `mapstructure:"canary-file"`
+	MinFiles   int    `mapstructure:"min-files"`
+	MinSize    string `mapstructure:"min-size"`
+	MaxShrink  int    `mapstructure:"max-shrink"`
+}
+
+// preconditionError is returned if a precondition of a backup job is not met
+type preconditionError struct {
+	msg string
+}
+
+func (e *preconditionError) Error() string {
+	return "precondition failed: " + e.msg
+}
+
+func preconditionFailed(format string, args ...interface{}) error {
+	return &preconditionError{msg: errors.Errorf(format, args...).Error()}
+}
+
+// isPreconditionError reports whether the cause of err is a failed precondition
+func isPreconditionError(err error) bool {
+	_, ok := errors.Cause(err).(*preconditionError)
+	return ok
+}
+
+// blockedError is returned by forget jobs on a repository where a backup precondition failed
+type blockedError struct {
+	repoID string
+}
+
+func (e *blockedError) Error() string {
+	return "a backup precondition for repository \"" + e.repoID + "\" failed"
+}
+
+// IsBlocked reports whether err was returned by a forget job that was not executed because a
+// backup precondition for its repository failed
+func IsBlocked(err error) bool {
+	_, ok := errors.Cause(err).(*blockedError)
+	return ok
+}
+
+func (p *Preconditions) needsScan() bool {
+	return p.MinFiles > 0 || p.MinSize != "" || p.MaxShrink > 0
+}
+
+// check verifies all configured preconditions for the source path
+func (p *Preconditions) check(ctx context.Context, source string, repo Repository, repoArgs []string, fields log.Fields) error {
+	if p.Mountpoint {
+		// a missing or unreadable source must block forget like an unmounted one
+		mounted, err := isMountpoint(source)
+		if err != nil {
+			return preconditionFailed("mountpoint check of source \"%s\" failed: %s", source, err)
+		}
+
+		if !mounted {
+			return preconditionFailed("source \"%s\" is not a mountpoint", source)
+		}
+	}
+
+	if p.CanaryFile != "" {
+		canary := p.CanaryFile
+		if !filepath.IsAbs(canary) {
+			canary = filepath.Join(source, canary)
+		}
+
+		if _, err := os.Stat(canary); err != nil {
+			return preconditionFailed("canary file \"%s\" does not exist", canary)
+		}
+	}
+
+	if !p.needsScan() {
+		return nil
+	}
+
+	files, size, skipped, err := scan(source)
+	if err != nil {
+		return preconditionFailed("scan of source \"%s\" failed: %s", source, err)
+	}
+
+	log.WithFields(fields).WithFields(log.Fields{
+		"files":   files,
+		"size":    size,
+		"skipped": skipped,
+	}).Info("scanned backup source")
+
+	if p.MinFiles > 0 && files < int64(p.MinFiles) {
+		return preconditionFailed("source contains %d files. At least %d files are required", files, p.MinFiles)
+	}
+
+	if p.MinSize != "" {
+		minSize, err := parseSize(p.MinSize)
+		if err != nil {
+			return errors.Wrap(err, "invalid min-size")
+		}
+
+		if size < minSize {
+			return preconditionFailed("source size is %d bytes. At least %d bytes are required", size, minSize)
+		}
+	}
+
+	if p.MaxShrink > 0 {
+		previous, exists, err := latestSnapshotStats(ctx, source, repo, repoArgs, fields)
+		if err != nil {
+			return errors.Wrap(err, "get previous snapshot statistics failed")
+		}
+
+		if !exists {
+			log.WithFields(fields).Info("no previous snapshot found. Skipping shrink check")
+			return nil
+		}
+
+		if s := shrink(previous.TotalFileCount, files); s > p.MaxShrink {
+			return preconditionFailed("file count shrank by %d%% (%d -> %d). At most %d%% are allowed", s, previous.TotalFileCount, files, p.MaxShrink)
+		}
+
+		if s := shrink(previous.TotalSize, size); s > p.MaxShrink {
+			return preconditionFailed("size shrank by %d%% (%d -> %d bytes). At most %d%% are allowed", s, previous.TotalSize, size, p.MaxShrink)
+		}
+	}
+
+	return nil
+}
+
+// shrink returns by how many percent current is smaller than previous
+func shrink(previous, current int64) int {
+	if previous <= 0 || current >= previous {
+		return 0
+	}
+
+	return int((previous - current) * 100 / previous)
+}
+
+// scan returns the number of files and the total size of all files below path. Files and
+// directories that can not be read or vanish during the scan are skipped and counted.
+func scan(path string) (files int64, size int64, skipped int64, err error) {
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == path || !(os.IsPermission(err) || os.IsNotExist(err)) {
+				return err
+			}
+
+			skipped++
+
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return files, size, skipped, err
+}
+
+type snapshotStats struct {
+	TotalSize      int64 `json:"total_size"`
+	TotalFileCount int64 `json:"total_file_count"`
+}
+
+// latestSnapshotStats returns the restore size statistics of the latest snapshot of path
+func latestSnapshotStats(ctx context.Context, path string, repo Repository, repoArgs []string, fields log.Fields) (stats snapshotStats, exists bool, err error) {
+	latest, err := snapshots(ctx, path, repo, repoArgs, true, fields)
+	if err != nil {
+		return stats, false, err
+	}
+
+	if len(latest) == 0 {
+		return stats, false, nil
+	}
+
+	args := []string{"stats", latest[len(latest)-1].ID, "--json", "--mode", "restore-size"}
+	args = append(args, repoArgs...)
+
+	out, err := executeOutput(ctx, args, repo.Password, fields)
+	if err != nil {
+		return stats, false, err
+	}
+
+	if err := json.Unmarshal(out, &stats); err != nil {
+		return stats, false, errors.Wrap(err, "parse stats failed")
+	}
+
+	return stats, true, nil
+}
+
+// parseSize parses a size in bytes. The suffixes K, M, G and T can be used.
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+
+	multiplier := int64(1)
+	if l := len(value); l > 0 {
+		switch strings.ToUpper(value[l-1:]) {
+		case "B":
+			value = value[:l-1]
+		case "K":
+			multiplier = 1 << 10
+			value = value[:l-1]
+		case "M":
+			multiplier = 1 << 20
+			value = value[:l-1]
+		case "G":
+			multiplier = 1 << 30
+			value = value[:l-1]
+		case "T":
+			multiplier = 1 << 40
+			value = value[:l-1]
+		}
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("invalid size \"%s\"", value)
+	}
+
+	return int64(v * float64(multiplier)), nil
+}
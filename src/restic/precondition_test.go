@@ -0,0 +1,76 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package restic
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		valid bool
+	}{
+		{"0", 0, true},
+		{"512", 512, true},
+		{"512B", 512, true},
+		{"1K", 1 << 10, true},
+		{"1k", 1 << 10, true},
+		{"1.5M", 3 << 19, true},
+		{"10G", 10 << 30, true},
+		{" 2T ", 2 << 40, true},
+		{"", 0, false},
+		{"-1K", 0, false},
+		{"1P", 0, false},
+		{"large", 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseSize(test.value)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("parseSize(%q) error = %v, want valid %t", test.value, err, test.valid)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("parseSize(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestShrink(t *testing.T) {
+	tests := []struct {
+		previous, current int64
+		want              int
+	}{
+		{100, 100, 0},
+		{100, 120, 0},
+		{100, 50, 50},
+		{100, 0, 100},
+		{0, 0, 0},
+	}
+
+	for _, test := range tests {
+		if got := shrink(test.previous, test.current); got != test.want {
+			t.Errorf("shrink(%d, %d) = %d, want %d", test.previous, test.current, got, test.want)
+		}
+	}
+}
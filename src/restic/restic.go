@@ -21,20 +21,30 @@
 package restic
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/process"
+	"github.com/th3noname/backup-and-sync/src/retry"
 )
 
-type job interface {
-	run(Repository) error
+// Job is a single restic job. It can be executed with Restic.RunJob.
+type Job interface {
+	run(context.Context, Repository, *Result) error
 	name() string
 	continueOnError() bool
+	timeout() time.Duration
+	retryPolicy() retry.Policy
 	logFields() log.Fields
 	repoID() string
 }
@@ -48,64 +58,248 @@ type Config struct {
 
 // Repository stores information on a restic repository
 type Repository struct {
-	Repository string `mapstructure:"repository"`
-	Path       string `mapstructure:"path"`
-	Password   string `mapstructure:"password"`
+	Repository      string  `mapstructure:"repository"`
+	Path            string  `mapstructure:"path"`
+	Password        string  `mapstructure:"password"`
+	ReadConcurrency int     `mapstructure:"read-concurrency"`
+	Options         Options `mapstructure:",squash"`
+}
+
+// Options stores restic settings that are set on a repository and can be overridden by a job
+type Options struct {
+	Compression   string `mapstructure:"compression"`
+	PackSize      int    `mapstructure:"pack-size"`
+	LimitUpload   string `mapstructure:"limit-upload"`
+	LimitDownload string `mapstructure:"limit-download"`
+	CacheDir      string `mapstructure:"cache-dir"`
+	NoCache       *bool  `mapstructure:"no-cache"`
+}
+
+// merge returns a copy of the options where all unset values are taken from defaults
+func (o Options) merge(defaults Options) Options {
+	if o.Compression == "" {
+		o.Compression = defaults.Compression
+	}
+
+	if o.PackSize <= 0 {
+		o.PackSize = defaults.PackSize
+	}
+
+	if o.LimitUpload == "" {
+		o.LimitUpload = defaults.LimitUpload
+	}
+
+	if o.LimitDownload == "" {
+		o.LimitDownload = defaults.LimitDownload
+	}
+
+	if o.CacheDir == "" {
+		o.CacheDir = defaults.CacheDir
+	}
+
+	if o.NoCache == nil {
+		o.NoCache = defaults.NoCache
+	}
+
+	return o
+}
+
+// accessArgs returns the repository path and the cache arguments. Unlike args it sets no
+// options that require a specific repository version.
+func (r *Repository) accessArgs(o Options) []string {
+	args := []string{"--repo", r.Path}
+
+	if o.NoCache != nil && *o.NoCache {
+		args = append(args, "--no-cache")
+	} else if o.CacheDir != "" {
+		args = append(args, "--cache-dir", o.CacheDir)
+	}
+
+	return args
+}
+
+// args returns the global restic arguments needed to access the repository.
+// The job options override the repository options if set.
+func (r *Repository) args(jobOptions Options) ([]string, error) {
+	o := jobOptions.merge(r.Options)
+
+	args := r.accessArgs(o)
+
+	switch o.Compression {
+	case "":
+	case "auto", "max", "off":
+		args = append(args, "--compression", o.Compression)
+	default:
+		return nil, errors.Errorf("invalid compression mode \"%s\". Valid modes are auto, max and off", o.Compression)
+	}
+
+	if o.PackSize > 0 {
+		args = append(args, "--pack-size", strconv.Itoa(o.PackSize))
+	}
+
+	now := time.Now()
+
+	upload, err := bandwidthLimit(o.LimitUpload, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid limit-upload")
+	}
+
+	if upload > 0 {
+		args = append(args, "--limit-upload", strconv.Itoa(upload))
+	}
+
+	download, err := bandwidthLimit(o.LimitDownload, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid limit-download")
+	}
+
+	if download > 0 {
+		args = append(args, "--limit-download", strconv.Itoa(download))
+	}
+
+	return args, nil
+}
+
+// Result contains the outcome of a single job
+type Result struct {
+	Job     string
+	Fields  log.Fields
+	Summary *Summary
 }
 
 // Restic is a CLI wrapper
 type Restic struct {
 	config *Config
+
+	// blocked contains the repositories where forget jobs must not run
+	// because a backup precondition failed
+	blocked map[string]bool
+	mutex   *sync.Mutex
 }
 
 // New creates a Restic wrapper instance for the provided config
 func New(conf *Config) Restic {
-	return Restic{config: conf}
+	return Restic{config: conf, blocked: make(map[string]bool), mutex: &sync.Mutex{}}
 }
 
-// Run the configured restic jobs
-func (r *Restic) Run() error {
-	for _, v := range r.config.Backup {
-		err := r.callJob(&v)
+// Jobs returns all jobs configured in the backups and forget lists
+func (c *Config) Jobs() []Job {
+	var jobs []Job
 
-		if err != nil {
-			return err
-		}
+	for i := range c.Backup {
+		jobs = append(jobs, &c.Backup[i])
 	}
 
-	for _, v := range r.config.Forget {
-		err := r.callJob(&v)
-
-		if err != nil {
-			return err
-		}
+	for i := range c.Forget {
+		jobs = append(jobs, &c.Forget[i])
 	}
 
-	return nil
+	return jobs
 }
 
-func (r *Restic) callJob(j job) error {
+// RunJob executes a single job and returns its statistics. The restic process is terminated if
+// ctx is cancelled.
+func (r *Restic) RunJob(ctx context.Context, j Job) (Result, error) {
+	res := Result{Job: j.name(), Fields: j.logFields()}
+
 	repo, exists := r.repository(j.repoID())
 	if !exists {
-		if j.continueOnError() {
-			log.WithFields(j.logFields()).Warnf("run %s job failed. repository \"%s\" does not exist. Continuing...", j.name(), j.repoID())
-			return nil
-		}
+		return res, errors.Errorf("run %s job failed. repository \"%s\" does not exist", j.name(), j.repoID())
+	}
 
-		return errors.Errorf("run %s job failed. repository \"%s\" does not exist", j.name(), j.repoID())
+	if _, isForget := j.(*Forget); isForget && r.isBlocked(j.repoID()) {
+		return res, errors.Wrapf(&blockedError{repoID: j.repoID()}, "run %s job skipped", j.name())
 	}
 
-	err := j.run(repo)
+	err := j.run(ctx, repo, &res)
 
 	if err != nil {
-		if j.continueOnError() {
-			log.WithError(err).WithFields(j.logFields()).Warnf("run %s job failed. Continuing...", j.name())
-			return nil
+		if isPreconditionError(err) {
+			r.mutex.Lock()
+			r.blocked[j.repoID()] = true
+			r.mutex.Unlock()
 		}
 
-		return errors.Wrapf(err, "run %s job failed", j.name())
+		return res, errors.Wrapf(err, "run %s job failed", j.name())
+	}
+
+	return res, nil
+}
+
+func (r *Restic) isBlocked(repoID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.blocked[repoID]
+}
+
+// RepositoryID returns the id of the repository the job uses
+func RepositoryID(j Job) string {
+	return j.repoID()
+}
+
+// Paths returns the paths the job accesses: the path of its repository and the source of a
+// backup. The rclone: prefix of a repository is removed, so the path can be compared with the
+// paths of rclone jobs.
+func (r *Restic) Paths(j Job) []string {
+	var paths []string
+
+	if repo, exists := r.repository(j.repoID()); exists {
+		paths = append(paths, strings.TrimPrefix(repo.Path, "rclone:"))
+	}
+
+	if b, ok := j.(*Backup); ok {
+		paths = append(paths, b.Source)
+	}
+
+	return paths
+}
+
+// ContinueOnError reports whether the remaining jobs are executed if the job fails
+func ContinueOnError(j Job) bool {
+	return j.continueOnError()
+}
+
+// Timeout returns the maximum duration of the job. Zero means no limit.
+func Timeout(j Job) time.Duration {
+	return j.timeout()
+}
+
+// RetryPolicy returns how often and when the job is retried after a retryable error
+func RetryPolicy(j Job) retry.Policy {
+	return j.retryPolicy()
+}
+
+// Migrate upgrades the repository with the provided id to the repository format version 2.
+// The repository is checked first, the migration is only started if the check succeeds.
+func (r *Restic) Migrate(ctx context.Context, repoID string) error {
+	repo, exists := r.repository(repoID)
+	if !exists {
+		return errors.Errorf("repository \"%s\" does not exist", repoID)
+	}
+
+	fields := log.Fields{
+		"repository": repo.Repository,
+	}
+
+	// version 1 repositories reject compression and pack size settings
+	args := repo.accessArgs(repo.Options)
+
+	log.WithFields(fields).Info("start check restic repository")
+
+	err := execute(ctx, append([]string{"check"}, args...), repo.Password, fields)
+	if err != nil {
+		return errors.Wrap(err, "repository check failed. Migration aborted")
+	}
+
+	log.WithFields(fields).Info("start migrate restic repository")
+
+	err = execute(ctx, append([]string{"migrate", "upgrade_repo_v2"}, args...), repo.Password, fields)
+	if err != nil {
+		return errors.Wrap(err, "repository migration failed")
 	}
 
+	log.WithFields(fields).Info("end migrate restic repository")
 	return nil
 }
 
@@ -119,29 +313,114 @@ func (r *Restic) repository(key string) (repo Repository, exists bool) {
 	return Repository{}, false
 }
 
-func execute(arguments []string, password string) error {
-	log.WithField("arguments", arguments).Info("Executing restic command")
+func execute(ctx context.Context, arguments []string, password string, fields log.Fields) error {
+	logger := log.WithFields(fields)
+	logger.WithField("arguments", arguments).Info("Executing restic command")
+
+	stdout := logger.WriterLevel(log.InfoLevel)
+	defer stdout.Close()
+
+	logStderr := logger.WriterLevel(log.WarnLevel)
+	defer logStderr.Close()
+
+	stderr := &errorDetector{w: logStderr}
 
 	command := exec.Command("restic", arguments...)
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
+	command.Stdout = stdout
+	command.Stderr = stderr
 	command.Env = append(os.Environ(), fmt.Sprintf("RESTIC_PASSWORD=%s", password))
-	err := command.Run()
+	err := process.Run(ctx, command)
 
 	if err == nil {
-		log.Info("restic exited with return code 0")
+		logger.Info("restic exited with return code 0")
 	}
 
-	return errors.Wrap(err, "restic exec failed")
+	return errors.Wrap(classify(err, stderr), "restic exec failed")
+}
+
+// restic exit codes
+const (
+	exitFatal   = 1
+	exitPartial = 3
+	exitLocked  = 11
+)
+
+// permanentErrors are messages of errors that a retry does not fix. Restic versions before
+// 0.17 exit with the fatal exit code for them instead of the exit codes 10 and 12.
+var permanentErrors = []string{
+	"wrong password",
+	"repository does not exist",
+	"Is there a repository at the following location?",
+}
+
+// errorDetector passes the stderr of restic to a writer and detects permanent errors
+type errorDetector struct {
+	w         io.Writer
+	permanent bool
+}
+
+func (d *errorDetector) Write(p []byte) (int, error) {
+	for _, msg := range permanentErrors {
+		if bytes.Contains(p, []byte(msg)) {
+			d.permanent = true
+		}
+	}
+
+	return d.w.Write(p)
+}
+
+// classify sets the class of a failed restic execution. Fatal errors are often caused by network
+// or backend problems and are retried like a locked repository, unless restic reported a
+// permanent error on stderr. A missing repository, a wrong password, a partial backup and all
+// other exit codes are not retried.
+func classify(err error, stderr *errorDetector) error {
+	code, ok := retry.ExitCode(err)
+	if !ok {
+		return err
+	}
+
+	switch {
+	case code == exitFatal && stderr.permanent:
+		return retry.Classify(err, retry.Fatal)
+	case code == exitFatal, code == exitLocked:
+		return retry.Classify(err, retry.Retryable)
+	default:
+		return retry.Classify(err, retry.Fatal)
+	}
+}
+
+// executeOutput runs restic and returns the output written to stdout. Messages on stderr are
+// logged with the fields of the job.
+func executeOutput(ctx context.Context, arguments []string, password string, fields log.Fields) ([]byte, error) {
+	logger := log.WithFields(fields)
+	logger.WithField("arguments", arguments).Debug("Executing restic command")
+
+	logStderr := logger.WriterLevel(log.WarnLevel)
+	defer logStderr.Close()
+
+	stderr := &errorDetector{w: logStderr}
+
+	command := exec.Command("restic", arguments...)
+	command.Stderr = stderr
+	command.Env = append(os.Environ(), fmt.Sprintf("RESTIC_PASSWORD=%s", password))
+	out, err := process.Output(ctx, command)
+
+	return out, errors.Wrap(classify(err, stderr), "restic exec failed")
 }
 
 // Backup represents a single restic backup job
 type Backup struct {
-	Backup          string   `mapstructure:"backup"`
-	Repository      string   `mapstructure:"repository"`
-	Source          string   `mapstructure:"source"`
-	Exclude         []string `mapstructure:"exclude"`
-	ContinueOnError bool     `mapstructure:"continue-on-error"`
+	Backup           string        `mapstructure:"backup"`
+	Repository       string        `mapstructure:"repository"`
+	Source           string        `mapstructure:"source"`
+	Exclude          []string      `mapstructure:"exclude"`
+	ReadConcurrency  int           `mapstructure:"read-concurrency"`
+	Options          Options       `mapstructure:",squash"`
+	Preconditions    Preconditions `mapstructure:"preconditions"`
+	PartialAsWarning bool          `mapstructure:"partial-as-warning"`
+	ContinueOnError  bool          `mapstructure:"continue-on-error"`
+	Timeout          time.Duration `mapstructure:"timeout"`
+	Retry            retry.Policy  `mapstructure:",squash"`
 }
 
 func (b *Backup) name() string {
@@ -156,6 +435,14 @@ func (b *Backup) continueOnError() bool {
 	return b.ContinueOnError
 }
 
+func (b *Backup) timeout() time.Duration {
+	return b.Timeout
+}
+
+func (b *Backup) retryPolicy() retry.Policy {
+	return b.Retry
+}
+
 func (b *Backup) logFields() log.Fields {
 	return log.Fields{
 		"backup":     b.Backup,
@@ -165,37 +452,63 @@ func (b *Backup) logFields() log.Fields {
 	}
 }
 
-func (b *Backup) run(repo Repository) error {
+func (b *Backup) run(ctx context.Context, repo Repository, res *Result) error {
 	log.WithFields(b.logFields()).Infof("start run restic %s", b.name())
 
+	repoArgs, err := repo.args(b.Options)
+	if err != nil {
+		return err
+	}
+
+	err = b.Preconditions.check(ctx, b.Source, repo, repoArgs, b.logFields())
+	if err != nil {
+		return err
+	}
+
 	args := []string{b.name()}
 	args = append(args, b.Source)
-	args = append(args, "--repo", repo.Path)
+	args = append(args, repoArgs...)
 
 	for _, v := range b.Exclude {
 		args = append(args, "--exclude", v)
 	}
 
-	err := execute(args, repo.Password)
+	readConcurrency := b.ReadConcurrency
+	if readConcurrency <= 0 {
+		readConcurrency = repo.ReadConcurrency
+	}
+
+	if readConcurrency > 0 {
+		args = append(args, "--read-concurrency", strconv.Itoa(readConcurrency))
+	}
+
+	res.Summary, err = executeBackup(ctx, args, repo.Password, b.logFields())
+
+	if code, ok := retry.ExitCode(err); ok && code == exitPartial && b.PartialAsWarning {
+		err = retry.Classify(errors.Wrap(err, "snapshot created, but some source files could not be read"), retry.Warning)
+	}
 
-	log.Infof("end run restic %s", b.name())
+	log.WithFields(b.logFields()).Infof("end run restic %s", b.name())
 	return errors.Wrap(err, "execute failed")
 }
 
 // Forget represents a single restic forget job
 type Forget struct {
-	Repository      string   `mapstructure:"repository"`
-	Prune           bool     `mapstructure:"prune"`
-	KeepLast        int      `mapstructure:"keep-last"`
-	KeepHourly      int      `mapstructure:"keep-hourly"`
-	KeepDaily       int      `mapstructure:"keep-daily"`
-	KeepWeekly      int      `mapstructure:"keep-weekly"`
-	KeepMonthly     int      `mapstructure:"keep-monthly"`
-	KeepYearly      int      `mapstructure:"keep-yearly"`
-	KeepTag         []string `mapstructure:"keep-tag"`
-	Tag             []string `mapstructure:"tag"`
-	Hostname        string   `mapstructure:"hostname"`
-	ContinueOnError bool     `mapstructure:"continue-on-error"`
+	Repository      string        `mapstructure:"repository"`
+	Prune           bool          `mapstructure:"prune"`
+	KeepLast        int           `mapstructure:"keep-last"`
+	KeepHourly      int           `mapstructure:"keep-hourly"`
+	KeepDaily       int           `mapstructure:"keep-daily"`
+	KeepWeekly      int           `mapstructure:"keep-weekly"`
+	KeepMonthly     int           `mapstructure:"keep-monthly"`
+	KeepYearly      int           `mapstructure:"keep-yearly"`
+	KeepTag         []string      `mapstructure:"keep-tag"`
+	Tag             []string      `mapstructure:"tag"`
+	Hostname        string        `mapstructure:"hostname"`
+	Options         Options       `mapstructure:",squash"`
+	ContinueOnError bool          `mapstructure:"continue-on-error"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	Retry           retry.Policy  `mapstructure:",squash"`
 }
 
 func (f *Forget) name() string {
@@ -210,6 +523,14 @@ func (f *Forget) continueOnError() bool {
 	return f.ContinueOnError
 }
 
+func (f *Forget) timeout() time.Duration {
+	return f.Timeout
+}
+
+func (f *Forget) retryPolicy() retry.Policy {
+	return f.Retry
+}
+
 func (f *Forget) logFields() log.Fields {
 	return log.Fields{
 		"repository": f.Repository,
@@ -217,11 +538,16 @@ func (f *Forget) logFields() log.Fields {
 	}
 }
 
-func (f *Forget) run(repo Repository) error {
+func (f *Forget) run(ctx context.Context, repo Repository, res *Result) error {
 	log.WithFields(f.logFields()).Infof("start run restic %s", f.name())
 
+	repoArgs, err := repo.args(f.Options)
+	if err != nil {
+		return err
+	}
+
 	args := []string{f.name()}
-	args = append(args, "--repo", repo.Path)
+	args = append(args, repoArgs...)
 
 	if f.Hostname != "" {
 		args = append(args, "--hostname", f.Hostname)
@@ -263,8 +589,8 @@ func (f *Forget) run(repo Repository) error {
 		args = append(args, "--prune")
 	}
 
-	err := execute(args, repo.Password)
+	err = execute(ctx, args, repo.Password, f.logFields())
 
-	log.Infof("end run restic %s", f.name())
+	log.WithFields(f.logFields()).Infof("end run restic %s", f.name())
 	return errors.Wrap(err, "execute failed")
 }
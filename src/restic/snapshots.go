@@ -0,0 +1,74 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot is a restic snapshot
+type Snapshot struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// snapshots returns the snapshots of path sorted by time. Only the latest snapshot is returned
+// if latest is set.
+func snapshots(ctx context.Context, path string, repo Repository, repoArgs []string, latest bool, fields log.Fields) ([]Snapshot, error) {
+	args := []string{"snapshots", "--json", "--path", path}
+	if latest {
+		args = append(args, "--latest", "1")
+	}
+
+	args = append(args, repoArgs...)
+
+	out, err := executeOutput(ctx, args, repo.Password, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Snapshot
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, errors.Wrap(err, "parse snapshots failed")
+	}
+
+	return result, nil
+}
+
+// Snapshots returns the snapshots the backup job created in its repository sorted by time
+func (r *Restic) Snapshots(ctx context.Context, b *Backup) ([]Snapshot, error) {
+	repo, exists := r.repository(b.Repository)
+	if !exists {
+		return nil, errors.Errorf("repository \"%s\" does not exist", b.Repository)
+	}
+
+	repoArgs, err := repo.args(b.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshots(ctx, b.Source, repo, repoArgs, false, b.logFields())
+}
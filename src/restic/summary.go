@@ -0,0 +1,158 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package restic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/process"
+)
+
+// Summary contains the statistics reported by restic at the end of a backup
+type Summary struct {
+	FilesNew            int64   `json:"files_new"`
+	FilesChanged        int64   `json:"files_changed"`
+	FilesUnmodified     int64   `json:"files_unmodified"`
+	DataAdded           int64   `json:"data_added"`
+	TotalFilesProcessed int64   `json:"total_files_processed"`
+	TotalBytesProcessed int64   `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"`
+	SnapshotID          string  `json:"snapshot_id"`
+}
+
+// backupMessage is a single line written by restic backup with --json
+type backupMessage struct {
+	MessageType string          `json:"message_type"`
+	Error       json.RawMessage `json:"error"`
+	During      string          `json:"during"`
+	Item        string          `json:"item"`
+}
+
+// executeBackup runs restic backup with json output. Errors reported by restic are logged with
+// the job fields and the final summary is returned.
+func executeBackup(ctx context.Context, arguments []string, password string, fields log.Fields) (*Summary, error) {
+	arguments = append(arguments, "--json")
+
+	logger := log.WithFields(fields)
+	logger.WithField("arguments", arguments).Info("Executing restic command")
+
+	logStderr := logger.WriterLevel(log.WarnLevel)
+	defer logStderr.Close()
+
+	stderr := &errorDetector{w: logStderr}
+
+	command := exec.Command("restic", arguments...)
+	command.Stderr = stderr
+	command.Env = append(os.Environ(), fmt.Sprintf("RESTIC_PASSWORD=%s", password))
+
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "restic exec failed")
+	}
+
+	err = process.Start(command)
+	if err != nil {
+		return nil, errors.Wrap(err, "restic exec failed")
+	}
+
+	stop := process.Watch(ctx, command)
+
+	summary, readErr := readBackupOutput(stdout, logger)
+	if readErr != nil {
+		logger.WithError(readErr).Warn("reading restic output failed")
+		io.Copy(ioutil.Discard, stdout)
+	}
+
+	err = command.Wait()
+	stop()
+
+	if err == nil {
+		logger.Info("restic exited with return code 0")
+	}
+
+	return summary, errors.Wrap(classify(err, stderr), "restic exec failed")
+}
+
+// readBackupOutput logs the messages of restic backup and returns the summary
+func readBackupOutput(r io.Reader, logger *log.Entry) (*Summary, error) {
+	var summary *Summary
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var msg backupMessage
+		if json.Unmarshal(line, &msg) != nil {
+			if text := strings.TrimSpace(string(line)); text != "" {
+				logger.Info(text)
+			}
+
+			continue
+		}
+
+		switch msg.MessageType {
+		case "summary":
+			summary = &Summary{}
+			if err := json.Unmarshal(line, summary); err != nil {
+				return nil, err
+			}
+
+			logger.WithFields(log.Fields{
+				"files-new":       summary.FilesNew,
+				"files-changed":   summary.FilesChanged,
+				"data-added":      summary.DataAdded,
+				"files-processed": summary.TotalFilesProcessed,
+				"bytes-processed": summary.TotalBytesProcessed,
+				"snapshot":        summary.SnapshotID,
+			}).Info("restic backup summary")
+		case "error":
+			logger.WithFields(log.Fields{"during": msg.During, "item": msg.Item}).Warnf("restic error: %s", errorMessage(msg.Error))
+		}
+	}
+
+	return summary, scanner.Err()
+}
+
+// errorMessage returns the message of an error reported by restic. Older restic versions do
+// not report an object with a message.
+func errorMessage(raw json.RawMessage) string {
+	var e struct {
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(raw, &e) == nil && e.Message != "" {
+		return e.Message
+	}
+
+	return string(raw)
+}
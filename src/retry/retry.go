@@ -0,0 +1,153 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package retry classifies job errors and contains the retry policy of jobs
+package retry
+
+import (
+	"context"
+	"math"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Class describes how the runner handles a job error
+type Class int
+
+const (
+	// Fatal errors fail the job without a retry
+	Fatal Class = iota
+	// Retryable errors are retried according to the retry policy of the job
+	Retryable
+	// Warning errors do not fail the job. The job did its work, but not completely.
+	Warning
+)
+
+func (c Class) String() string {
+	switch c {
+	case Retryable:
+		return "retryable"
+	case Warning:
+		return "warning"
+	default:
+		return "fatal"
+	}
+}
+
+type classified struct {
+	class Class
+	err   error
+}
+
+func (c *classified) Error() string {
+	return c.err.Error()
+}
+
+// Classify attaches the class to err. It returns nil if err is nil.
+func Classify(err error, class Class) error {
+	if err == nil {
+		return nil
+	}
+
+	return &classified{class: class, err: err}
+}
+
+// ClassOf returns the class of the outermost classified error. Errors that were not classified
+// are fatal.
+func ClassOf(err error) Class {
+	for err != nil {
+		switch e := err.(type) {
+		case *classified:
+			return e.class
+		case interface{ Cause() error }:
+			err = e.Cause()
+		default:
+			return Fatal
+		}
+	}
+
+	return Fatal
+}
+
+// ExitCode returns the exit code of the process that caused err
+func ExitCode(err error) (int, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *exec.ExitError:
+			return e.ExitCode(), true
+		case *classified:
+			err = e.err
+		case interface{ Cause() error }:
+			err = e.Cause()
+		default:
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+// Policy configures how often and when a job is retried
+type Policy struct {
+	Retries int           `mapstructure:"retries"`
+	Delay   time.Duration `mapstructure:"retry-delay"`
+	Backoff float64       `mapstructure:"retry-backoff"`
+}
+
+// Validate checks the settings of the policy
+func (p Policy) Validate() error {
+	if p.Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+
+	if p.Delay < 0 {
+		return errors.New("retry-delay must not be negative")
+	}
+
+	if p.Backoff != 0 && p.Backoff < 1 {
+		return errors.New("retry-backoff must be at least 1")
+	}
+
+	return nil
+}
+
+// Wait blocks until the retry with the number attempt (starting at 1) is due. The delay is
+// multiplied by the backoff factor for every further retry. It returns early with the error of
+// ctx if ctx is done.
+func (p Policy) Wait(ctx context.Context, attempt int) error {
+	backoff := p.Backoff
+	if backoff == 0 {
+		backoff = 1
+	}
+
+	delay := time.Duration(float64(p.Delay) * math.Pow(backoff, float64(attempt-1)))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package scheduler runs the scheduled jobs of a configuration until it is stopped
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/th3noname/backup-and-sync/src/jobs"
+	"github.com/th3noname/backup-and-sync/src/lock"
+)
+
+// catchUpRetry is the time after which a due job that did not succeed is caught up again
+const catchUpRetry = time.Hour
+
+// batch contains the jobs started by one activation of a trigger
+type batch struct {
+	trigger string
+	jobs    []string
+}
+
+// Scheduler starts the jobs of the runner when their triggers activate. The jobs of a trigger
+// are executed like a backup run. Runs are executed one after another and a job that is still
+// queued or running is not started again. Jobs with an interval are caught up as soon as their
+// last success is older than the interval, e.g. after the machine was switched off.
+type Scheduler struct {
+	runner   *jobs.Runner
+	lockFile string
+
+	mutex       *sync.Mutex
+	active      map[string]bool
+	queue       chan batch
+	lastCatchUp map[string]time.Time
+}
+
+// New creates a Scheduler. Every run holds the lock file like the backup command.
+func New(runner *jobs.Runner, lockFile string) *Scheduler {
+	return &Scheduler{
+		runner:      runner,
+		lockFile:    lockFile,
+		mutex:       &sync.Mutex{},
+		active:      make(map[string]bool),
+		lastCatchUp: make(map[string]time.Time),
+	}
+}
+
+// Run executes the scheduled jobs until ctx is cancelled. A running job is cancelled with ctx.
+func (s *Scheduler) Run(ctx context.Context) error {
+	triggers := s.runner.Triggers()
+	if len(triggers) == 0 && !s.runner.HasIntervals() {
+		return errors.New("no job has a schedule or an interval")
+	}
+
+	// every job is queued at most once, so the queue never blocks
+	s.queue = make(chan batch, len(s.runner.Jobs()))
+
+	due := make([]time.Time, len(triggers))
+
+	now := time.Now()
+	for i := range triggers {
+		due[i] = nextRun(&triggers[i], now)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.work(ctx)
+	}()
+
+	// the wall clock is checked at least every minute, timers do not advance while the
+	// machine sleeps
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping scheduler")
+			<-done
+			return nil
+		case <-timer.C:
+		}
+
+		now := time.Now()
+
+		for i := range triggers {
+			if due[i].IsZero() || due[i].After(now) {
+				continue
+			}
+
+			// activations missed while the machine was sleeping are skipped
+			s.trigger(&triggers[i])
+			due[i] = nextRun(&triggers[i], now)
+		}
+
+		s.catchUp(now)
+
+		wait := time.Minute
+		if i := earliest(due); i >= 0 && time.Until(due[i]) < wait {
+			wait = time.Until(due[i])
+		}
+
+		timer.Reset(wait)
+	}
+}
+
+// nextRun returns the time the next run of the trigger after the provided time is due including
+// the jitter. The zero time is returned if the trigger never activates again.
+func nextRun(t *jobs.Trigger, after time.Time) time.Time {
+	next := t.Next(after)
+
+	if next.IsZero() {
+		log.WithFields(log.Fields{"schedule": t.Name, "jobs": t.Jobs}).Warn("schedule has no further runs")
+		return next
+	}
+
+	due := next.Add(t.Jitter())
+	log.WithFields(log.Fields{"schedule": t.Name, "jobs": t.Jobs, "next": due.Format(time.RFC3339)}).Info("next scheduled run")
+
+	return due
+}
+
+// trigger queues the jobs of the trigger that are not queued or running yet
+func (s *Scheduler) trigger(t *jobs.Trigger) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b := batch{trigger: t.Name}
+
+	for _, id := range t.Jobs {
+		if s.active[id] {
+			log.WithFields(log.Fields{"schedule": t.Name, "id": id}).Warn("job is still queued or running. Skipping this run")
+			continue
+		}
+
+		s.active[id] = true
+		b.jobs = append(b.jobs, id)
+	}
+
+	s.enqueue(b)
+}
+
+// catchUp queues the due jobs with an interval that are not queued or running. A job is caught
+// up at most once per catchUpRetry.
+func (s *Scheduler) catchUp(now time.Time) {
+	if !s.runner.HasIntervals() {
+		return
+	}
+
+	ids, err := s.runner.Due(now)
+	if err != nil {
+		log.WithError(err).Error("Checking due jobs failed")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b := batch{trigger: "catch-up"}
+
+	for _, id := range ids {
+		if s.active[id] || now.Sub(s.lastCatchUp[id]) < catchUpRetry {
+			continue
+		}
+
+		s.active[id] = true
+		s.lastCatchUp[id] = now
+		b.jobs = append(b.jobs, id)
+	}
+
+	s.enqueue(b)
+}
+
+// enqueue queues a batch with at least one job. The mutex must be held.
+func (s *Scheduler) enqueue(b batch) {
+	if len(b.jobs) == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{"schedule": b.trigger, "jobs": b.jobs}).Info("queue scheduled jobs")
+	s.queue <- b
+}
+
+// work executes the queued batches until ctx is cancelled
+func (s *Scheduler) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case b := <-s.queue:
+			s.execute(ctx, b)
+		}
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, b batch) {
+	fields := log.Fields{"schedule": b.trigger, "jobs": b.jobs}
+
+	defer func() {
+		s.mutex.Lock()
+		for _, id := range b.jobs {
+			delete(s.active, id)
+		}
+		s.mutex.Unlock()
+	}()
+
+	l, err := lock.Wait(ctx, s.lockFile, 0)
+	if err != nil {
+		log.WithError(err).WithFields(fields).Error("Acquiring lock failed")
+		return
+	}
+
+	defer func() {
+		if err := l.Release(); err != nil {
+			log.WithError(err).Warn("Releasing lock failed")
+		}
+	}()
+
+	log.WithFields(fields).Info("start scheduled run")
+
+	err = s.runner.RunJobs(ctx, b.trigger, b.jobs)
+	if err != nil {
+		log.WithError(err).WithFields(fields).Error("scheduled run failed")
+		return
+	}
+
+	log.WithFields(fields).Info("scheduled run finished successfully")
+}
+
+// earliest returns the index of the earliest time that is not zero or -1
+func earliest(times []time.Time) int {
+	index := -1
+
+	for i, t := range times {
+		if t.IsZero() {
+			continue
+		}
+
+		if index < 0 || t.Before(times[index]) {
+			index = i
+		}
+	}
+
+	return index
+}
@@ -0,0 +1,152 @@
+// Copyright © 2019 Jan Arens
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+// Package statefile stores state that is persisted between runs
+package statefile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const name = "backup-and-sync"
+
+var (
+	defaultDir  string
+	defaultOnce sync.Once
+)
+
+// DefaultDir returns the directory used if no state directory is configured. The cache
+// directory may be cleaned by the system, so the state is kept in $XDG_STATE_HOME
+// (~/.local/state) on unix, in ~/Library/Application Support on macOS and in %LocalAppData%
+// on windows. State of older versions is moved from the cache directory on first use.
+func DefaultDir() string {
+	defaultOnce.Do(func() {
+		defaultDir = userStateDir()
+		migrateCacheDir(defaultDir)
+	})
+
+	return defaultDir
+}
+
+func userStateDir() string {
+	var dir string
+
+	switch runtime.GOOS {
+	case "windows":
+		dir = os.Getenv("LocalAppData")
+	case "darwin":
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, "Library", "Application Support")
+		}
+	default:
+		dir = os.Getenv("XDG_STATE_HOME")
+		if !filepath.IsAbs(dir) {
+			dir = ""
+			if home, err := os.UserHomeDir(); err == nil {
+				dir = filepath.Join(home, ".local", "state")
+			}
+		}
+	}
+
+	if dir == "" {
+		return "." + name
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// migrateCacheDir moves the state directory of older versions from the user cache directory
+// to dir if dir does not exist yet
+func migrateCacheDir(dir string) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return
+	}
+
+	old := filepath.Join(cache, name)
+	if old == dir {
+		return
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return
+	}
+
+	if _, err := os.Stat(old); err != nil {
+		return
+	}
+
+	fields := log.Fields{"from": old, "to": dir}
+
+	err = os.MkdirAll(filepath.Dir(dir), 0700)
+	if err == nil {
+		err = os.Rename(old, dir)
+	}
+
+	if err != nil {
+		log.WithFields(fields).WithError(err).Warn("Moving the state directory failed")
+		return
+	}
+
+	log.WithFields(fields).Info("Moved the state directory out of the cache directory")
+}
+
+// Read decodes the JSON file at path into v. A missing file leaves v unchanged.
+func Read(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "read state file failed")
+	}
+
+	return errors.Wrapf(json.Unmarshal(data, v), "parse state file \"%s\" failed", path)
+}
+
+// Write encodes v as JSON and writes it atomically to path
+func Write(path string, v interface{}) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return errors.Wrap(err, "create state directory failed")
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode state failed")
+	}
+
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "write state file failed")
+	}
+
+	return errors.Wrap(os.Rename(tmp, path), "write state file failed")
+}